@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// adminCommands read or write the store and go through the admin API of a running server
+// when ADMIN_URL is set, since the server holds the store file exclusively
+var adminCommands = map[string]bool{"versions": true, "rollback": true, "audit": true}
+
+// UsesAdminAPI reports whether the command in args talks to a running server instead of
+// opening STORE_PATH itself
+func UsesAdminAPI(args []string) bool {
+	return len(args) > 0 && adminCommands[args[0]] && adminURL() != ""
+}
+
+// adminURL returns the base URL of the server from ADMIN_URL, e.g. http://localhost:8080
+func adminURL() string {
+	return strings.TrimSuffix(os.Getenv("ADMIN_URL"), "/")
+}
+
+// callAdmin sends a request to the admin API authenticated with ADMIN_TOKEN. The caller
+// closes the body of the returned response, which is only returned for 2xx statuses.
+func callAdmin(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := adminURL() + "/admin" + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("ADMIN_TOKEN"))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("admin API request failed: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var failure struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+			return nil, fmt.Errorf("admin API %s %s: %s", method, path, failure.Error)
+		}
+		return nil, fmt.Errorf("admin API %s %s returned %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// adminJSON calls the admin API and decodes its JSON response into out
+func adminJSON(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := callAdmin(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
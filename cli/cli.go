@@ -0,0 +1,423 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"env-updater/core"
+	"env-updater/services"
+	"env-updater/store"
+)
+
+// command is a CLI subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands lists the available subcommands in the order shown by usage
+var commands = []command{
+	{"serve", "run the webhook server (default)", nil},
+	{"sync", "fetch a file from GitHub and sync it to Azure DevOps", runSync},
+	{"plan", "show what sync would change without applying it", runPlan},
+	{"list-secure-files", "list the secure files in an Azure DevOps project", runListSecureFiles},
+	{"trigger", "queue a run of an Azure DevOps pipeline", runTrigger},
+	{"reconcile", "upload every routed file whose Azure copy differs from the repository", runReconcile},
+	{"drift", "report differences between repository files and Azure secure files", runDrift},
+	{"audit", "export the audit log as JSON lines or verify its hash chain", runAudit},
+	{"versions", "list the stored versions of a sink target", runVersions},
+	{"rollback", "re-upload a previous version of a sink target and trigger its pipelines", runRollback},
+}
+
+// IsHelp reports whether args only ask for the usage
+func IsHelp(args []string) bool {
+	return len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help"
+}
+
+// Run executes the subcommand named by args[0]
+func Run(args []string) error {
+	if IsHelp(args) {
+		printUsage(os.Stdout)
+		return nil
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] && cmd.run != nil {
+			err := cmd.run(context.Background(), args[1:])
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	printUsage(os.Stderr)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: env-updater <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-20s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'env-updater <command> -h' for the flags of a command.")
+	fmt.Fprintln(w, "With ADMIN_URL set, versions, rollback and audit use the admin API of a running server.")
+}
+
+// runSync syncs one or more files from a repository
+func runSync(ctx context.Context, args []string) error {
+	return syncFiles(ctx, "sync", args, false)
+}
+
+// runPlan reports the changes sync would make
+func runPlan(ctx context.Context, args []string) error {
+	return syncFiles(ctx, "plan", args, true)
+}
+
+// syncFiles implements sync and plan, which share their flags
+func syncFiles(ctx context.Context, name string, args []string, dryRun bool) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	repo := flags.String("repo", "", "repository as owner/name (required)")
+	ref := flags.String("ref", "", "branch, tag or commit SHA (default GITHUB_REF or main)")
+	var paths stringList
+	flags.Var(&paths, "path", "file path in the repository, may be repeated (required)")
+	var force bool
+	if !dryRun {
+		flags.BoolVar(&dryRun, "dry-run", false, "only report the planned changes")
+		flags.BoolVar(&force, "force", false, "upload and trigger even when the content is unchanged")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *repo == "" || len(paths) == 0 {
+		flags.Usage()
+		return fmt.Errorf("--repo and --path are required")
+	}
+
+	// The process exits once done, so pipelines are triggered before returning rather than debounced
+	opts := services.ProcessOptions{DryRun: dryRun, Force: force, NoDebounce: true}
+	plan, errs := services.SyncFiles(ctx, *repo, *ref, paths, opts)
+	var failed []string
+	for _, path := range paths {
+		if err, ok := errs[path]; ok {
+			failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+
+	if err := printJSON(plan); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d file(s) failed:\n  %s", len(failed), strings.Join(failed, "\n  "))
+	}
+	return nil
+}
+
+// runListSecureFiles prints the secure files of a project
+func runListSecureFiles(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list-secure-files", flag.ContinueOnError)
+	project := flags.String("project", os.Getenv("AZURE_DEVOPS_PROJECT"), "Azure DevOps project (required)")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	pat, org, err := azureCredentials()
+	if err != nil {
+		return err
+	}
+	if *project == "" {
+		flags.Usage()
+		return fmt.Errorf("--project is required")
+	}
+
+	files, err := core.ListSecureFiles(ctx, pat, org, *project)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(files)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tMODIFIED")
+	for _, file := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", file.Id, file.Name, file.ModifiedOn)
+	}
+	return w.Flush()
+}
+
+// runTrigger queues a pipeline run by id or name
+func runTrigger(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("trigger", flag.ContinueOnError)
+	project := flags.String("project", os.Getenv("AZURE_DEVOPS_PROJECT"), "Azure DevOps project (required)")
+	pipeline := flags.String("pipeline", "", "pipeline id or exact name (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *project == "" || *pipeline == "" {
+		flags.Usage()
+		return fmt.Errorf("--project and --pipeline are required")
+	}
+
+	triggered, err := services.TriggerPipeline(ctx, *project, *pipeline)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Triggered pipeline %s (%d) in project %s\n", triggered.Name, triggered.Id, *project)
+	return nil
+}
+
+// runReconcile reconciles one repository or all of RECONCILE_REPOS
+func runReconcile(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repo := flags.String("repo", "", "repository as owner/name (default all of RECONCILE_REPOS)")
+	ref := flags.String("ref", "", "branch, tag or commit SHA (default GITHUB_REF or main)")
+	dryRun := flags.Bool("dry-run", false, "only report which files differ")
+	trigger := flags.Bool("trigger", false, "trigger the matching pipelines of uploaded files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := services.ProcessOptions{DryRun: *dryRun, SkipTrigger: !*trigger, NoDebounce: true}
+	if *repo != "" {
+		result, err := services.Reconcile(ctx, *repo, *ref, opts)
+		if err != nil {
+			return err
+		}
+		return printJSON([]*services.ReconcileResult{result})
+	}
+
+	results, err := services.ReconcileAll(ctx, opts)
+	if printErr := printJSON(results); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// runDrift prints a drift report for one repository or all of RECONCILE_REPOS
+func runDrift(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+	repo := flags.String("repo", "", "repository as owner/name (default all of RECONCILE_REPOS)")
+	ref := flags.String("ref", "", "branch, tag or commit SHA (default GITHUB_REF or main)")
+	asJSON := flags.Bool("json", false, "print JSON instead of text")
+	failOnDrift := flags.Bool("fail-on-drift", false, "exit non-zero when drift is found")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var targets []services.ReconcileTarget
+	if *repo != "" {
+		targets = append(targets, services.ReconcileTarget{Repository: *repo, Ref: *ref})
+	}
+
+	report, err := services.DetectDrift(ctx, targets)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		err = printJSON(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *failOnDrift && report.HasDrift() {
+		return fmt.Errorf("drift detected")
+	}
+	return nil
+}
+
+// runAudit exports or verifies the audit log in STORE_PATH
+func runAudit(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	verify := flags.Bool("verify", false, "check the hash chain instead of exporting")
+	entryType := flags.String("type", "", "only export entries of this type (sync, permissions, pipeline_run)")
+	repo := flags.String("repo", "", "only export entries for this repository")
+	since := flags.String("since", "", "only export entries at or after this RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := store.AuditFilter{Type: *entryType, Repository: *repo}
+	if *since != "" {
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			return fmt.Errorf("invalid --since %q: %v", *since, err)
+		}
+		filter.Since = t
+	}
+
+	if adminURL() != "" {
+		return adminAudit(ctx, *verify, *entryType, *repo, *since)
+	}
+
+	db := store.Default()
+	if db == nil {
+		return fmt.Errorf("no store configured, set STORE_PATH, or ADMIN_URL to use a running server")
+	}
+
+	if *verify {
+		count, err := db.VerifyAudit()
+		if err != nil {
+			return fmt.Errorf("audit log verification failed after %d entries: %v", count, err)
+		}
+		fmt.Printf("Audit log verified, %d entries\n", count)
+		return nil
+	}
+	return db.ExportAudit(os.Stdout, filter)
+}
+
+// adminAudit exports or verifies the audit log of a running server
+func adminAudit(ctx context.Context, verify bool, entryType, repo, since string) error {
+	if verify {
+		var result struct {
+			Entries int `json:"entries"`
+		}
+		if err := adminJSON(ctx, http.MethodGet, "/audit/verify", nil, nil, &result); err != nil {
+			return fmt.Errorf("audit log verification failed: %v", err)
+		}
+		fmt.Printf("Audit log verified, %d entries\n", result.Entries)
+		return nil
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"type": entryType, "repository": repo, "since": since} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	resp, err := callAdmin(ctx, http.MethodGet, "/audit/export", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// runVersions lists the version history of a target
+func runVersions(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("versions", flag.ContinueOnError)
+	key := flags.String("key", "", "target key as shown in plans, e.g. secure_file:api/api_app.env (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" {
+		flags.Usage()
+		return fmt.Errorf("--key is required")
+	}
+
+	var versions []store.Version
+	if adminURL() != "" {
+		var result struct {
+			Versions []store.Version `json:"versions"`
+		}
+		err := adminJSON(ctx, http.MethodGet, "/versions", url.Values{"key": {*key}}, nil, &result)
+		if err != nil {
+			return err
+		}
+		versions = result.Versions
+	} else {
+		var err error
+		if versions, err = services.ListVersions(*key); err != nil {
+			return err
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME\tREPOSITORY\tPATH\tREF\tSTORED")
+	for _, version := range versions {
+		stored := "reference"
+		if version.Encrypted {
+			stored = "encrypted"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.12s\t%s\n", version.Id, version.Time.Format(time.RFC3339),
+			version.Repository, version.Path, version.Ref, stored)
+	}
+	return w.Flush()
+}
+
+// runRollback restores a previous version of a target
+func runRollback(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	key := flags.String("key", "", "target key as shown in plans and by versions (required)")
+	version := flags.Uint64("version", 0, "version to restore (default the one before the current version)")
+	dryRun := flags.Bool("dry-run", false, "only report the planned changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" {
+		flags.Usage()
+		return fmt.Errorf("--key is required")
+	}
+
+	if adminURL() != "" {
+		var result struct {
+			Plan services.FilePlan `json:"plan"`
+		}
+		request := map[string]interface{}{"key": *key, "version": *version, "dry_run": *dryRun}
+		if err := adminJSON(ctx, http.MethodPost, "/rollback", nil, request, &result); err != nil {
+			return err
+		}
+		return printJSON(result.Plan)
+	}
+
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "cli"
+	}
+	filePlan, err := services.Rollback(ctx, *key, *version, actor, services.ProcessOptions{DryRun: *dryRun, NoDebounce: true})
+	if err != nil {
+		return err
+	}
+	return printJSON(filePlan)
+}
+
+// azureCredentials reads the Azure DevOps PAT and organization from the environment
+func azureCredentials() (string, string, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	if pat == "" || org == "" {
+		return "", "", fmt.Errorf("missing environment variables: AZURE_DEVOPS_PAT or AZURE_DEVOPS_ORG")
+	}
+	return pat, org, nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// stringList is a flag that may be given several times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
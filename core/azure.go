@@ -1,208 +1,384 @@
 package core
 
 import (
-    "bytes"
-    "context"
-    "encoding/base64"
-    "encoding/json"
-    "fmt"
-    "io"
-    "log"
-    "net/http"
-    "os"
-    "time"
-
-    "github.com/joho/godotenv"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
 )
 
 // LoadEnv loads environment variables from a .env file
 func init() {
-    err := godotenv.Load()
-    if err != nil {
-        log.Println("No .env file found. Proceeding with system environment variables...")
-    }
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found. Proceeding with system environment variables...")
+	}
 }
 
 // UpdateAzureDevOpsFile deletes the existing file and uploads a new version, using dynamically selected project
 func UpdateAzureDevOpsFile(ctx context.Context, filename string) error {
-    // Retrieve Azure DevOps configuration 
-    pat := os.Getenv("AZURE_DEVOPS_PAT")
-    org := os.Getenv("AZURE_DEVOPS_ORG")
-    project := os.Getenv("AZURE_DEVOPS_PROJECT")
-
-    // log.Printf("AZURE_DEVOPS_PAT: [REDACTED]")
-    // log.Printf("AZURE_DEVOPS_ORG: %s", org)
-    // log.Printf("AZURE_DEVOPS_PROJECT: %s", project)
-
-    // Validate environment variables 
-    if pat == "" {
-        return fmt.Errorf("missing environment variable: AZURE_DEVOPS_PAT")
-    }
-    if org == "" {
-        return fmt.Errorf("missing environment variable: AZURE_DEVOPS_ORG")
-    }
-    if project == "" {
-        return fmt.Errorf("missing environment variable: AZURE_DEVOPS_PROJECT")
-    }
-
-    // Define the path to "security.txt"
-    securityFilePath := "security.txt"
-
-    // Ensure the security.txt file exists
-    if _, err := os.Stat(securityFilePath); os.IsNotExist(err) {
-        return fmt.Errorf("security.txt file does not exist in the root directory")
-    }
-    // log.Println("Confirmed security.txt exists")
-
-    // Read the content of "security.txt"
-    content, err := os.ReadFile(securityFilePath)
-    if err != nil {
-        return fmt.Errorf("failed to read security.txt: %v", err)
-    }
-    // log.Printf("Successfully read security.txt with %d bytes", len(content))
-
-    // Check if the file exists before attempting to delete
-    fileExists, secureFileId, err := checkFileExists(ctx, filename, pat, org, project)
-    if err != nil {
-        return fmt.Errorf("error checking if file exists: %v", err)
-    }
-
-    if fileExists {
-        // Delete the existing file
-        deleteErr := deleteFile(ctx, secureFileId, pat, org, project)
-        if deleteErr != nil {
-            return fmt.Errorf("error deleting file: %v", deleteErr)
-        }
-        // log.Printf("File %s was successfully deleted", filename)
-    } else {
-        log.Printf("File %s not found, proceeding to upload", filename)
-    }
-
-    // Now, upload the new version
-    apiURL := fmt.Sprintf(
-        "https://dev.azure.com/%s/%s/_apis/distributedtask/securefiles?api-version=7.1-preview.1&name=%s",
-        org,
-        project,
-        filename,
-    )
-
-    // log.Printf("Preparing to upload file %s to Azure DevOps in project %s", filename, project)
-    // log.Printf("Request URL: %s", apiURL)
-
-    // Create HTTP request for file upload with context
-    req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(content))
-    if err != nil {
-        return fmt.Errorf("failed to create upload request: %v", err)
-    }
-
-    // Set headers
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-    req.Header.Set("Content-Type", "application/octet-stream")
-    // log.Printf("Request Headers: %+v", req.Header)
-
-    // Execute request
-    client := &http.Client{
-        Timeout: 30 * time.Second, // Set a timeout
-    }
-    resp, err := client.Do(req)
-    if err != nil {
-        return fmt.Errorf("failed to send upload request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    // Read full response body for detailed logging
-    bodyBytes, _ := io.ReadAll(resp.Body)
-
-    // Check response status
-    if resp.StatusCode != http.StatusCreated {
-        // log.Printf("Azure DevOps API Response - Status: %d, Body: %s", resp.StatusCode, string(bodyBytes))
-        return fmt.Errorf("file upload failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
-    }
-
-    log.Printf("File %s successfully uploaded to Azure DevOps with status: %d", filename, resp.StatusCode)
-
-    return nil
+	// Retrieve Azure DevOps configuration
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	project := os.Getenv("AZURE_DEVOPS_PROJECT")
+
+	// Validate environment variables
+	if pat == "" {
+		return fmt.Errorf("missing environment variable: AZURE_DEVOPS_PAT")
+	}
+	if org == "" {
+		return fmt.Errorf("missing environment variable: AZURE_DEVOPS_ORG")
+	}
+	if project == "" {
+		return fmt.Errorf("missing environment variable: AZURE_DEVOPS_PROJECT")
+	}
+
+	// Define the path to "security.txt"
+	securityFilePath := "security.txt"
+
+	// Ensure the security.txt file exists
+	if _, err := os.Stat(securityFilePath); os.IsNotExist(err) {
+		return fmt.Errorf("security.txt file does not exist in the root directory")
+	}
+	// log.Println("Confirmed security.txt exists")
+
+	// Read the content of "security.txt"
+	content, err := os.ReadFile(securityFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read security.txt: %v", err)
+	}
+	// log.Printf("Successfully read security.txt with %d bytes", len(content))
+
+	_, err = UploadSecureFile(ctx, pat, org, project, filename, content)
+	return err
 }
 
-// checkFileExists checks if a file with given name exists in Azure DevOps Secure Files
-func checkFileExists(ctx context.Context, filename, pat, org, project string) (bool, string, error) {
-    apiURL := fmt.Sprintf(
-        "https://dev.azure.com/%s/%s/_apis/distributedtask/securefiles?api-version=7.1-preview.1",
-        org,
-        project,
-    )
-
-    req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-    if err != nil {
-        return false, "", fmt.Errorf("failed to create get request for file check: %v", err)
-    }
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-
-    client := &http.Client{
-        Timeout: 30 * time.Second,
-    }
-    resp, err := client.Do(req)
-    if err != nil {
-        return false, "", fmt.Errorf("failed to send get request for file check: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        return false, "", fmt.Errorf("failed to get secure files list: status code %d", resp.StatusCode)
-    }
-
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        return false, "", fmt.Errorf("failed to read response body: %v", err)
-    }
-
-    var secureFiles struct {
-        Value []struct {
-            Id   string `json:"id"`
-            Name string `json:"name"`
-        } `json:"value"`
-    }
-
-    if err := json.Unmarshal(body, &secureFiles); err != nil {
-        return false, "", fmt.Errorf("failed to unmarshal JSON response: %v", err)
-    }
-
-    for _, file := range secureFiles.Value {
-        if file.Name == filename {
-            return true, file.Id, nil
-        }
-    }
-
-    return false, "", nil
+// UploadSecureFile replaces a secure file with content, deleting any previous version,
+// and records the content hash on the new file
+func UploadSecureFile(ctx context.Context, pat, org, project, filename string, content []byte) (*SecureFile, error) {
+	// Check if the file exists before attempting to delete
+	fileExists, secureFileId, err := CheckFileExists(ctx, filename, pat, org, project)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if file exists: %v", err)
+	}
+
+	if fileExists {
+		// Delete the existing file
+		deleteErr := DeleteSecureFile(ctx, secureFileId, pat, org, project)
+		if deleteErr != nil {
+			return nil, fmt.Errorf("error deleting file: %v", deleteErr)
+		}
+		// log.Printf("File %s was successfully deleted", filename)
+	} else {
+		log.Printf("File %s not found, proceeding to upload", filename)
+	}
+
+	// Now, upload the new version
+	defer invalidateSecureFiles(org, project)
+	apiURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/distributedtask/securefiles?api-version=7.1-preview.1&name=%s",
+		AzureBaseURL(), org,
+		project,
+		url.QueryEscape(filename),
+	)
+
+	// log.Printf("Preparing to upload file %s to Azure DevOps in project %s", filename, project)
+	// log.Printf("Request URL: %s", apiURL)
+
+	// Create HTTP request for file upload with context
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %v", err)
+	}
+
+	// Set headers
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// log.Printf("Request Headers: %+v", req.Header)
+
+	// Execute request
+	client := &http.Client{
+		Timeout: 30 * time.Second, // Set a timeout
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send upload request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read full response body for detailed logging
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	// Check response status, Azure DevOps answers uploads with either 200 or 201
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		// log.Printf("Azure DevOps API Response - Status: %d, Body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, fmt.Errorf("file upload failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	log.Printf("File %s successfully uploaded to Azure DevOps with status: %d", filename, resp.StatusCode)
+
+	// Record the content hash so later syncs can detect drift without downloading the file
+	var uploaded SecureFile
+	if err := json.Unmarshal(bodyBytes, &uploaded); err != nil || uploaded.Id == "" {
+		log.Printf("Could not read secure file id for %s from upload response, content hash not recorded", filename)
+		return &SecureFile{Name: filename}, nil
+	}
+	uploaded.Properties = map[string]string{ContentHashProperty: TargetHash(content)}
+	if err := SetSecureFileProperties(ctx, pat, org, project, uploaded.Id, filename, uploaded.Properties); err != nil {
+		log.Printf("Failed to record content hash for %s: %v", filename, err)
+	}
+
+	return &uploaded, nil
 }
 
-// deleteFile deletes a file from Azure DevOps Secure Files library
-func deleteFile(ctx context.Context, secureFileId, pat, org, project string) error {
-    deleteURL := fmt.Sprintf(
-        "https://dev.azure.com/%s/%s/_apis/distributedtask/securefiles/%s?api-version=7.1-preview.1",
-        org,
-        project,
-        secureFileId,
-    )
-
-    req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
-    if err != nil {
-        return fmt.Errorf("failed to create delete request: %v", err)
-    }
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-
-    client := &http.Client{
-        Timeout: 30 * time.Second,
-    }
-    resp, err := client.Do(req)
-    if err != nil {
-        return fmt.Errorf("failed to send delete request: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusNoContent {
-        return fmt.Errorf("failed to delete file: status code %d", resp.StatusCode)
-    }
-
-    return nil
-}
\ No newline at end of file
+// ContentHashProperty is the secure file property holding the TargetHash of the uploaded content
+const ContentHashProperty = "contentHash"
+
+// ContentHash returns the hex-encoded sha256 of content
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// TargetHash returns the hex-encoded HMAC-SHA256 of content keyed with CONTENT_HASH_KEY. It is the
+// hash recorded next to content in sinks, where a plain sha256 would let anyone who can read the
+// target confirm a guess of its values. The key is required at startup, see main.
+func TargetHash(content []byte) string {
+	h := hmac.New(sha256.New, []byte(os.Getenv("CONTENT_HASH_KEY")))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SetSecureFileProperties replaces the properties stored on a secure file
+func SetSecureFileProperties(ctx context.Context, pat, org, project, secureFileId, filename string, properties map[string]string) error {
+	defer invalidateSecureFiles(org, project)
+	apiURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/distributedtask/securefiles/%s?api-version=7.1-preview.1",
+		AzureBaseURL(), org,
+		project,
+		secureFileId,
+	)
+
+	payloadBytes, err := json.Marshal(SecureFile{Id: secureFileId, Name: filename, Properties: properties})
+	if err != nil {
+		return fmt.Errorf("failed to marshal secure file properties: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", apiURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create properties request: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send properties request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update secure file properties: status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+// SecureFile is an entry in the Azure DevOps Secure Files library
+type SecureFile struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Properties map[string]string `json:"properties,omitempty"`
+	ModifiedOn string            `json:"modifiedOn,omitempty"`
+}
+
+// secureFileCache holds secure file listings per org/project for AZURE_CACHE_TTL (default 1m)
+var secureFileCache = NewTTLCache[[]SecureFile]("secure_files", "AZURE_CACHE_TTL", time.Minute)
+
+// invalidateSecureFiles drops the cached listing of a project after one of our writes
+func invalidateSecureFiles(org, project string) {
+	secureFileCache.Invalidate(org + "/" + project)
+}
+
+// ListSecureFiles returns all secure files in an Azure DevOps project, cached for AZURE_CACHE_TTL
+func ListSecureFiles(ctx context.Context, pat, org, project string) ([]SecureFile, error) {
+	return secureFileCache.Get(org+"/"+project, func() ([]SecureFile, error) {
+		return listSecureFiles(ctx, pat, org, project)
+	})
+}
+
+// listSecureFiles fetches all secure files in an Azure DevOps project
+func listSecureFiles(ctx context.Context, pat, org, project string) ([]SecureFile, error) {
+	apiURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/distributedtask/securefiles?api-version=7.1-preview.1",
+		AzureBaseURL(), org,
+		project,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create get request for secure files: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send get request for secure files: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get secure files list: status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	var secureFiles struct {
+		Value []SecureFile `json:"value"`
+	}
+
+	if err := json.Unmarshal(body, &secureFiles); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON response: %v", err)
+	}
+
+	return secureFiles.Value, nil
+}
+
+// FindSecureFile returns the secure file with the given name, or nil if it does not exist
+func FindSecureFile(ctx context.Context, pat, org, project, filename string) (*SecureFile, error) {
+	secureFiles, err := ListSecureFiles(ctx, pat, org, project)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range secureFiles {
+		if file.Name == filename {
+			return &file, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CheckFileExists checks if a file with given name exists in Azure DevOps Secure Files
+func CheckFileExists(ctx context.Context, filename, pat, org, project string) (bool, string, error) {
+	secureFiles, err := ListSecureFiles(ctx, pat, org, project)
+	if err != nil {
+		return false, "", err
+	}
+
+	for _, file := range secureFiles {
+		if file.Name == filename {
+			return true, file.Id, nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// DeleteSecureFile deletes a file from Azure DevOps Secure Files library
+func DeleteSecureFile(ctx context.Context, secureFileId, pat, org, project string) error {
+	defer invalidateSecureFiles(org, project)
+	deleteURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/distributedtask/securefiles/%s?api-version=7.1-preview.1",
+		AzureBaseURL(), org,
+		project,
+		secureFileId,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", deleteURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send delete request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to delete file: status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// AuthorizePipelines restricts a protected resource (e.g. "securefile" or "variablegroup")
+// to the given pipelines, revoking access for all other pipelines
+func AuthorizePipelines(ctx context.Context, pat, org, project, resourceType, resourceId string, pipelineIds []int) error {
+	apiURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/pipelines/pipelinePermissions/%s/%s?api-version=7.0-preview",
+		AzureBaseURL(), org, project, resourceType, resourceId,
+	)
+
+	pipelines := make([]map[string]interface{}, 0, len(pipelineIds))
+	for _, pipelineId := range pipelineIds {
+		pipelines = append(pipelines, map[string]interface{}{
+			"id":         pipelineId,
+			"authorized": true,
+		})
+	}
+
+	jsonPayload := map[string]interface{}{
+		"allPipelines": map[string]interface{}{
+			"authorized":   false,
+			"authorizedBy": nil,
+			"authorizedOn": nil,
+		},
+		"pipelines": pipelines,
+	}
+
+	payloadBytes, err := json.Marshal(jsonPayload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", apiURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLCache caches values such as Azure DevOps listings per key for a limited time.
+// A value fetched before an Invalidate call for its key is never stored, so a
+// listing that started before one of our own writes cannot bring stale data back.
+type TTLCache[T any] struct {
+	name    string
+	ttlEnv  string
+	ttlDef  time.Duration
+	ttl     time.Duration
+	ttlOnce sync.Once
+	mu      sync.Mutex
+	entries map[string]cacheEntry[T]
+	gens    map[string]uint64
+}
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// NewTTLCache creates a cache whose TTL is read from ttlEnv, falling back to def. A TTL of 0 disables it.
+// The TTL is read on first use, so caches declared as package variables see variables loaded from .env.
+func NewTTLCache[T any](name, ttlEnv string, def time.Duration) *TTLCache[T] {
+	return &TTLCache[T]{
+		name:    name,
+		ttlEnv:  ttlEnv,
+		ttlDef:  def,
+		entries: map[string]cacheEntry[T]{},
+		gens:    map[string]uint64{},
+	}
+}
+
+// Get returns the cached value for key, or calls fetch and caches its result
+func (c *TTLCache[T]) Get(key string, fetch func() (T, error)) (T, error) {
+	c.ttlOnce.Do(func() { c.ttl = EnvDuration(c.ttlEnv, c.ttlDef) })
+	if c.ttl <= 0 {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		IncCounter("cache_requests", "cache", c.name, "result", "hit")
+		return entry.value, nil
+	}
+	gen := c.gens[key]
+	c.mu.Unlock()
+	IncCounter("cache_requests", "cache", c.name, "result", "miss")
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	if c.gens[key] == gen {
+		c.entries[key] = cacheEntry[T]{value: value, expires: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Invalidate drops the cached value for key
+func (c *TTLCache[T]) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+	c.gens[key]++
+}
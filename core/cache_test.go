@@ -0,0 +1,46 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         string // AZURE_CACHE_TEST_TTL, set after the cache is created
+		invalidate  bool
+		wantFetches int
+	}{
+		{name: "values are cached for the TTL", ttl: "1m", wantFetches: 1},
+		{name: "a TTL of 0 disables the cache", ttl: "0s", wantFetches: 2},
+		{name: "invalidate drops the cached value", ttl: "1m", invalidate: true, wantFetches: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Package-level caches are created before .env is loaded, so the TTL must be read on first use
+			cache := NewTTLCache[int]("test", "AZURE_CACHE_TEST_TTL", time.Minute)
+			t.Setenv("AZURE_CACHE_TEST_TTL", tt.ttl)
+
+			fetches := 0
+			fetch := func() (int, error) {
+				fetches++
+				return fetches, nil
+			}
+
+			if _, err := cache.Get("org/project", fetch); err != nil {
+				t.Fatal(err)
+			}
+			if tt.invalidate {
+				cache.Invalidate("org/project")
+			}
+			if _, err := cache.Get("org/project", fetch); err != nil {
+				t.Fatal(err)
+			}
+			if fetches != tt.wantFetches {
+				t.Errorf("fetches = %d, want %d", fetches, tt.wantFetches)
+			}
+		})
+	}
+}
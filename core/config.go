@@ -0,0 +1,71 @@
+package core
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvBool reads a boolean environment variable, falling back to def when unset or invalid
+func EnvBool(name string, def bool) bool {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s (%q), using default %v", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// EnvInt reads an integer environment variable, falling back to def when unset or invalid
+func EnvInt(name string, def int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s (%q), using default %d", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// EnvDuration reads a duration such as "10m" from the environment, falling back to def
+func EnvDuration(name string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s (%q), using default %s", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// SplitList splits a comma-separated value, dropping empty entries
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// AzureBaseURL returns the Azure DevOps base URL, overridable with AZURE_DEVOPS_URL
+// (e.g. for Azure DevOps Server or a local test server)
+func AzureBaseURL() string {
+	if baseURL := strings.TrimRight(os.Getenv("AZURE_DEVOPS_URL"), "/"); baseURL != "" {
+		return baseURL
+	}
+	return "https://dev.azure.com"
+}
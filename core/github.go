@@ -3,19 +3,22 @@ package core
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/google/go-github/v50/github"
+	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
+	"hash"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
-    "github.com/google/go-github/v50/github"
-	"golang.org/x/oauth2"
-	"github.com/joho/godotenv"
 )
 
-
-
 // LoadEnv loads environment variables from a .env file
 func init() {
 	err := godotenv.Load()
@@ -24,36 +27,156 @@ func init() {
 	}
 }
 
+// WebhookSignatures holds the signature headers sent with a webhook delivery
+type WebhookSignatures struct {
+	SHA256 string // X-Hub-Signature-256
+	SHA1   string // X-Hub-Signature (legacy)
+}
+
+// SignatureMatch describes which secret and algorithm verified a delivery
+type SignatureMatch struct {
+	Algorithm string // "sha256" or "sha1"
+	Scope     string // "repo", "org" or "global"
+	SecretID  string // scope and position of the matching secret, e.g. "repo-0"; never derived from the secret
+	Index     int    // position of the secret in its list, 0 being the newest
+}
 
+// VerifyWebhookSignature verifies a sha256 signature against the global secrets.
+// Prefer VerifyWebhookRequest, which also supports sha1 and per-repository secrets.
 func VerifyWebhookSignature(payload []byte, signature string) bool {
-	secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
-	if secret == "" {
-		log.Println("GITHUB_WEBHOOK_SECRET is not set")
-		return false
+	_, ok := VerifyWebhookRequest(payload, WebhookSignatures{SHA256: signature}, "")
+	return ok
+}
+
+// VerifyWebhookRequest checks a delivery against every configured secret for the
+// repository, honouring the algorithm policy in GITHUB_WEBHOOK_ALGORITHMS.
+//
+// Secrets are looked up in order of specificity and only the first scope that
+// has any secrets configured is used:
+//   - GITHUB_WEBHOOK_SECRETS_REPO_<OWNER>_<REPO>
+//   - GITHUB_WEBHOOK_SECRETS_ORG_<OWNER>
+//   - GITHUB_WEBHOOK_SECRETS, then the legacy GITHUB_WEBHOOK_SECRET
+//
+// Each variable holds a comma-separated list so that old and new secrets can
+// both be accepted during a rotation window.
+func VerifyWebhookRequest(payload []byte, sigs WebhookSignatures, repoFullName string) (SignatureMatch, bool) {
+	scope, secrets := webhookSecretsFor(repoFullName)
+	if len(secrets) == 0 {
+		log.Println("No webhook secret configured (GITHUB_WEBHOOK_SECRET or GITHUB_WEBHOOK_SECRETS)")
+		IncCounter("webhook_signature_failures", "reason", "no_secret")
+		return SignatureMatch{}, false
 	}
 
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write(payload)
-	expectedSignature := "sha256=" + hex.EncodeToString(h.Sum(nil))
+	algorithm, signature, hashFunc := selectSignature(sigs)
+	if algorithm == "" {
+		IncCounter("webhook_signature_failures", "reason", "no_acceptable_signature")
+		return SignatureMatch{}, false
+	}
 
-	signature = strings.TrimSpace(signature) // Normalize input
-	return hmac.Equal([]byte(expectedSignature), []byte(signature))
+	for i, secret := range secrets {
+		h := hmac.New(hashFunc, []byte(secret))
+		h.Write(payload)
+		expectedSignature := algorithm + "=" + hex.EncodeToString(h.Sum(nil))
+
+		if hmac.Equal([]byte(expectedSignature), []byte(signature)) {
+			match := SignatureMatch{
+				Algorithm: algorithm,
+				Scope:     scope,
+				SecretID:  fmt.Sprintf("%s-%d", scope, i),
+				Index:     i,
+			}
+			if i > 0 {
+				log.Printf("Webhook for %s verified with older %s secret at position %d; consider retiring it", repoFullName, scope, i)
+			}
+			IncCounter("webhook_signature_matches", "algorithm", algorithm, "scope", scope, "secret", match.SecretID)
+			return match, true
+		}
+	}
+
+	IncCounter("webhook_signature_failures", "reason", "mismatch", "algorithm", algorithm)
+	return SignatureMatch{}, false
 }
 
-func FetchFileFromGitHub(repoFullName, filePath string) ([]byte, error) {
-	// Get GitHub token
-	token := os.Getenv("GITHUB_TOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("GitHub token not set")
+// webhookSecretsFor returns the most specific list of secrets configured for a repository
+func webhookSecretsFor(repoFullName string) (string, []string) {
+	if owner, repo, err := SplitRepositoryFullName(repoFullName); err == nil {
+		if secrets := SplitList(os.Getenv("GITHUB_WEBHOOK_SECRETS_REPO_" + envKey(owner) + "_" + envKey(repo))); len(secrets) > 0 {
+			return "repo", secrets
+		}
+		if secrets := SplitList(os.Getenv("GITHUB_WEBHOOK_SECRETS_ORG_" + envKey(owner))); len(secrets) > 0 {
+			return "org", secrets
+		}
 	}
 
-	// Create OAuth2 client
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	tc := oauth2.NewClient(ctx, ts)
+	secrets := SplitList(os.Getenv("GITHUB_WEBHOOK_SECRETS"))
+	if legacy := strings.TrimSpace(os.Getenv("GITHUB_WEBHOOK_SECRET")); legacy != "" {
+		secrets = append(secrets, legacy)
+	}
+	return "global", secrets
+}
+
+// selectSignature picks the strongest signature header allowed by the algorithm policy.
+// A sha1 signature is never used when a sha256 one was sent, to prevent downgrades.
+func selectSignature(sigs WebhookSignatures) (string, string, func() hash.Hash) {
+	allowed := map[string]bool{}
+	for _, algorithm := range SplitList(strings.ToLower(os.Getenv("GITHUB_WEBHOOK_ALGORITHMS"))) {
+		allowed[algorithm] = true
+	}
+	if len(allowed) == 0 {
+		allowed["sha256"] = true
+	}
+
+	sha256Sig := strings.TrimSpace(sigs.SHA256) // Normalize input
+	sha1Sig := strings.TrimSpace(sigs.SHA1)
+
+	if sha256Sig != "" {
+		if !allowed["sha256"] {
+			log.Println("Rejecting webhook: sha256 signatures are not allowed by GITHUB_WEBHOOK_ALGORITHMS")
+			return "", "", nil
+		}
+		return "sha256", sha256Sig, sha256.New
+	}
+	if sha1Sig != "" {
+		if !allowed["sha1"] {
+			log.Println("Rejecting webhook: only a sha1 signature was sent and sha1 is not allowed by GITHUB_WEBHOOK_ALGORITHMS")
+			return "", "", nil
+		}
+		return "sha1", sha1Sig, sha1.New
+	}
+
+	log.Println("Rejecting webhook: no signature header present")
+	return "", "", nil
+}
+
+// envKey converts an owner or repository name into an environment variable fragment
+func envKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
 
+// ErrFileNotFound is returned when a file does not exist in the repository at the requested ref
+var ErrFileNotFound = errors.New("file not found")
+
+// FetchFileFromGitHub fetches a file at the branch configured in GITHUB_REF (default "main")
+func FetchFileFromGitHub(repoFullName, filePath string) ([]byte, error) {
+	return FetchFileFromGitHubAtRef(context.Background(), repoFullName, filePath, "")
+}
+
+// FetchFileFromGitHubAtRef fetches a file at the given branch, tag or commit SHA.
+// An empty ref falls back to GITHUB_REF, then "main".
+func FetchFileFromGitHubAtRef(ctx context.Context, repoFullName, filePath, ref string) ([]byte, error) {
 	// Create GitHub client
-	client := github.NewClient(tc)
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// Parse repository owner and name
 	owner, repo, err := SplitRepositoryFullName(repoFullName)
@@ -62,19 +185,21 @@ func FetchFileFromGitHub(repoFullName, filePath string) ([]byte, error) {
 	}
 
 	// Fetch branch/ref
-	ref := os.Getenv("GITHUB_REF")
 	if ref == "" {
-		ref = "main"
+		ref = DefaultRef()
 	}
 
 	// Get file content
-	fileContent, _, _, err := client.Repositories.GetContents(
+	fileContent, _, resp, err := client.Repositories.GetContents(
 		ctx,
 		owner,
 		repo,
 		filePath,
 		&github.RepositoryContentGetOptions{Ref: ref},
 	)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s at %s", ErrFileNotFound, filePath, ref)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file content: %v", err)
 	}
@@ -90,19 +215,227 @@ func FetchFileFromGitHub(repoFullName, filePath string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to decode file content: %v", err)
 	}
 
-	// Log the file content
-	log.Println("Fetched File Content:")
-	log.Println(content)
+	// Log the fetch without the content, which may hold secrets
+	log.Printf("Fetched %s from %s at %s (%d bytes)", filePath, repoFullName, ref, len(content))
+
+	return []byte(content), nil
+}
 
-	// Save the file content to security.txt
-	filePathToSave := "security.txt"
-	err = os.WriteFile(filePathToSave, []byte(content), 0644)
+// RepositoryFile is a file entry in a repository tree
+type RepositoryFile struct {
+	Path string `json:"path"`
+	SHA  string `json:"sha"` // git blob SHA
+	Size int    `json:"size"`
+}
+
+// ListRepositoryFiles walks the full tree of a repository at ref and returns every file (blob)
+func ListRepositoryFiles(ctx context.Context, repoFullName, ref string) ([]RepositoryFile, error) {
+	client, err := NewGitHubClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write file to %s: %v", filePathToSave, err)
+		return nil, err
 	}
-	// log.Printf("File content saved to %s\n", filePathToSave)
 
-	return []byte(content), nil
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	if ref == "" {
+		ref = DefaultRef()
+	}
+
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for %s at %s: %v", repoFullName, ref, err)
+	}
+	if tree.GetTruncated() {
+		log.Printf("Tree for %s at %s was truncated by GitHub, some files are not listed", repoFullName, ref)
+	}
+
+	var files []RepositoryFile
+	for _, entry := range tree.Entries {
+		if entry.GetType() != "blob" {
+			continue
+		}
+		files = append(files, RepositoryFile{Path: entry.GetPath(), SHA: entry.GetSHA(), Size: entry.GetSize()})
+	}
+	return files, nil
+}
+
+// NewGitHubClient creates a GitHub client authenticated with GITHUB_TOKEN
+func NewGitHubClient(ctx context.Context) (*github.Client, error) {
+	// Get GitHub token
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("GitHub token not set")
+	}
+
+	// Create OAuth2 client
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(ctx, ts)
+	client := github.NewClient(tc)
+
+	// Point the client at GitHub Enterprise or a test server when configured
+	if apiURL := os.Getenv("GITHUB_API_URL"); apiURL != "" {
+		baseURL, err := url.Parse(strings.TrimRight(apiURL, "/") + "/")
+		if err != nil {
+			return nil, fmt.Errorf("invalid GITHUB_API_URL: %v", err)
+		}
+		client.BaseURL = baseURL
+	}
+
+	return client, nil
+}
+
+// CommitParent returns the first parent of a commit, or "" for a root commit
+func CommitParent(ctx context.Context, repoFullName, sha string) (string, error) {
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	commit, _, err := client.Git.GetCommit(ctx, owner, repo, sha)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit %s: %v", sha, err)
+	}
+	if len(commit.Parents) == 0 {
+		return "", nil
+	}
+	return commit.Parents[0].GetSHA(), nil
+}
+
+// CompareCommits returns how head relates to base: "identical", "ahead", "behind" or "diverged"
+func CompareCommits(ctx context.Context, repoFullName, base, head string) (string, error) {
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, base, head, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return "", fmt.Errorf("failed to compare %s with %s: %v", head, base, err)
+	}
+	return comparison.GetStatus(), nil
+}
+
+// SetCommitStatus creates a commit status on sha. The description is cut to the 140 characters GitHub accepts.
+func SetCommitStatus(ctx context.Context, repoFullName, sha, statusContext, state, description, targetURL string) error {
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	// GitHub limits descriptions to 140 characters, cut on a rune boundary to keep them valid UTF-8
+	if runes := []rune(description); len(runes) > 140 {
+		description = string(runes[:137]) + "..."
+	}
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Context:     github.String(statusContext),
+		Description: github.String(description),
+	}
+	if targetURL != "" {
+		status.TargetURL = github.String(targetURL)
+	}
+
+	if _, _, err := client.Repositories.CreateStatus(ctx, owner, repo, sha, status); err != nil {
+		return fmt.Errorf("failed to set %s status on %s@%s: %v", statusContext, repoFullName, sha, err)
+	}
+	return nil
+}
+
+// ListPullRequestFiles returns the paths added, modified or renamed by a pull request
+func ListPullRequestFiles(ctx context.Context, repoFullName string, number int) ([]string, error) {
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	var paths []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of %s#%d: %v", repoFullName, number, err)
+		}
+		for _, file := range files {
+			if file.GetStatus() != "removed" {
+				paths = append(paths, file.GetFilename())
+			}
+		}
+		if resp.NextPage == 0 {
+			return paths, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// UpsertIssueComment creates a comment on an issue or pull request, or edits the
+// existing comment containing marker so that repeated runs update a single comment
+func UpsertIssueComment(ctx context.Context, repoFullName string, number int, marker, body string) error {
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	owner, repo, err := SplitRepositoryFullName(repoFullName)
+	if err != nil {
+		return fmt.Errorf("invalid repository name: %v", err)
+	}
+
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list comments of %s#%d: %v", repoFullName, number, err)
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), marker) {
+				_, _, err := client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{Body: github.String(body)})
+				if err != nil {
+					return fmt.Errorf("failed to update comment on %s#%d: %v", repoFullName, number, err)
+				}
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if _, _, err := client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: github.String(body)}); err != nil {
+		return fmt.Errorf("failed to comment on %s#%d: %v", repoFullName, number, err)
+	}
+	return nil
+}
+
+// DefaultRef returns the branch files are synced from, GITHUB_REF or "main"
+func DefaultRef() string {
+	if ref := os.Getenv("GITHUB_REF"); ref != "" {
+		return ref
+	}
+	return "main"
 }
 
 // SplitRepositoryFullName splits a full repository name into owner and repo.
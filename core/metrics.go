@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry holds in-process counters keyed by name and label set
+var metricsRegistry = struct {
+	sync.Mutex
+	counters map[string]int64
+}{counters: make(map[string]int64)}
+
+// IncCounter increments a named counter. Labels are passed as key/value pairs,
+// e.g. IncCounter("webhook_signature_matches", "algorithm", "sha256").
+func IncCounter(name string, labels ...string) {
+	AddCounter(name, 1, labels...)
+}
+
+// AddCounter adds delta to a named counter
+func AddCounter(name string, delta int64, labels ...string) {
+	key := metricKey(name, labels)
+
+	metricsRegistry.Lock()
+	metricsRegistry.counters[key] += delta
+	metricsRegistry.Unlock()
+}
+
+// MetricsSnapshot returns a copy of all counters
+func MetricsSnapshot() map[string]int64 {
+	metricsRegistry.Lock()
+	defer metricsRegistry.Unlock()
+
+	snapshot := make(map[string]int64, len(metricsRegistry.counters))
+	for key, value := range metricsRegistry.counters {
+		snapshot[key] = value
+	}
+	return snapshot
+}
+
+// WriteMetrics writes all counters in the Prometheus text exposition format
+func WriteMetrics(w io.Writer) error {
+	snapshot := MetricsSnapshot()
+
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s %d\n", key, snapshot[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metricKey renders a counter name and its labels as name{k="v",...}
+func metricKey(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// VariableGroup is an Azure DevOps library variable group
+type VariableGroup struct {
+	Id          int                      `json:"id,omitempty"`
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Type        string                   `json:"type"`
+	Variables   map[string]GroupVariable `json:"variables"`
+}
+
+// GroupVariable is a single variable in a variable group. Secret values are never returned by Azure.
+type GroupVariable struct {
+	Value    string `json:"value"`
+	IsSecret bool   `json:"isSecret"`
+}
+
+// FindVariableGroup returns the variable group with the given name, or nil if it does not exist
+func FindVariableGroup(ctx context.Context, pat, org, project, name string) (*VariableGroup, error) {
+	apiURL := fmt.Sprintf(
+		"%s/%s/%s/_apis/distributedtask/variablegroups?groupName=%s&api-version=7.1-preview.2",
+		AzureBaseURL(), org, project, url.QueryEscape(name),
+	)
+
+	var result struct {
+		Value []VariableGroup `json:"value"`
+	}
+	if err := azureJSONRequest(ctx, pat, "GET", apiURL, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to look up variable group %s: %v", name, err)
+	}
+
+	for _, group := range result.Value {
+		if group.Name == name {
+			return &group, nil
+		}
+	}
+	return nil, nil
+}
+
+// SaveVariableGroup creates the group when group.Id is zero, otherwise replaces its variables.
+// Variables missing from group.Variables are removed from Azure.
+func SaveVariableGroup(ctx context.Context, pat, org, project string, group VariableGroup) (*VariableGroup, error) {
+	projectId, err := GetProjectId(ctx, pat, org, project)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.Type == "" {
+		group.Type = "Vsts"
+	}
+	payload := map[string]interface{}{
+		"name":        group.Name,
+		"description": group.Description,
+		"type":        group.Type,
+		"variables":   group.Variables,
+		"variableGroupProjectReferences": []map[string]interface{}{
+			{
+				"name":             group.Name,
+				"description":      group.Description,
+				"projectReference": map[string]string{"id": projectId, "name": project},
+			},
+		},
+	}
+
+	method := "POST"
+	apiURL := fmt.Sprintf("%s/%s/_apis/distributedtask/variablegroups?api-version=7.1-preview.2", AzureBaseURL(), org)
+	if group.Id != 0 {
+		method = "PUT"
+		apiURL = fmt.Sprintf("%s/%s/_apis/distributedtask/variablegroups/%d?api-version=7.1-preview.2", AzureBaseURL(), org, group.Id)
+	}
+
+	var saved VariableGroup
+	if err := azureJSONRequest(ctx, pat, method, apiURL, payload, &saved); err != nil {
+		return nil, fmt.Errorf("failed to save variable group %s: %v", group.Name, err)
+	}
+	return &saved, nil
+}
+
+// DeleteVariableGroup deletes a variable group from a project
+func DeleteVariableGroup(ctx context.Context, pat, org, project string, groupId int) error {
+	projectId, err := GetProjectId(ctx, pat, org, project)
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s/%s/_apis/distributedtask/variablegroups/%d?projectIds=%s&api-version=7.1-preview.2", AzureBaseURL(), org, groupId, projectId)
+	if err := azureJSONRequest(ctx, pat, "DELETE", apiURL, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete variable group %d: %v", groupId, err)
+	}
+	return nil
+}
+
+// GetProjectId resolves an Azure DevOps project name to its id
+func GetProjectId(ctx context.Context, pat, org, project string) (string, error) {
+	apiURL := fmt.Sprintf("%s/%s/_apis/projects/%s?api-version=7.1-preview.4", AzureBaseURL(), org, url.PathEscape(project))
+
+	var result struct {
+		Id string `json:"id"`
+	}
+	if err := azureJSONRequest(ctx, pat, "GET", apiURL, nil, &result); err != nil {
+		return "", fmt.Errorf("failed to get project %s: %v", project, err)
+	}
+	return result.Id, nil
+}
+
+// azureJSONRequest sends a JSON request to Azure DevOps and decodes a 200 or 201 response into out
+func azureJSONRequest(ctx context.Context, pat, method, apiURL string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	if out != nil && len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
@@ -6,8 +6,10 @@ require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/go-github/v50 v50.2.0
 	github.com/joho/godotenv v1.5.1
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.11.0
 	golang.org/x/oauth2 v0.10.0
-
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -33,11 +35,9 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/net v0.12.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
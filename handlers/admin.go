@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"env-updater/core"
+	"env-updater/services"
+	"env-updater/store"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdminToken protects admin endpoints with bearer tokens. ADMIN_TOKENS holds
+// comma-separated name:token pairs, one per operator, and ADMIN_TOKEN a shared token named
+// "admin". The name of the matching token is set as "admin" on the context and recorded as
+// the actor of changes made through the API.
+// Admin endpoints are disabled when neither is set.
+func RequireAdminToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokens := adminTokens()
+		if len(tokens) == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Admin API disabled"})
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		name := ""
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token.token)) == 1 {
+				name = token.name
+			}
+		}
+		if name == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Set("admin", name)
+		c.Next()
+	}
+}
+
+// adminToken is a bearer token accepted by the admin API and the name it identifies
+type adminToken struct {
+	name  string
+	token string
+}
+
+// adminTokens reads the tokens from ADMIN_TOKENS and ADMIN_TOKEN
+func adminTokens() []adminToken {
+	var tokens []adminToken
+	for _, pair := range core.SplitList(os.Getenv("ADMIN_TOKENS")) {
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			log.Printf("Ignoring malformed ADMIN_TOKENS entry, expected name:token")
+			continue
+		}
+		tokens = append(tokens, adminToken{name: name, token: token})
+	}
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		tokens = append(tokens, adminToken{name: "admin", token: token})
+	}
+	return tokens
+}
+
+// HandleReconcile reconciles one repository (?repo=owner/name&ref=main) or all configured ones.
+// Pass dry_run=true to only report drift and trigger=true to also trigger pipelines.
+func HandleReconcile(c *gin.Context) {
+	opts := services.ProcessOptions{
+		DryRun:      c.Query("dry_run") == "true",
+		SkipTrigger: c.Query("trigger") != "true",
+	}
+
+	if repo := c.Query("repo"); repo != "" {
+		result, err := services.Reconcile(c.Request.Context(), repo, c.Query("ref"), opts)
+		if err != nil {
+			log.Printf("Reconcile error for %s: %v", repo, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"results": []*services.ReconcileResult{result}})
+		return
+	}
+
+	results, err := services.ReconcileAll(c.Request.Context(), opts)
+	if err != nil {
+		log.Printf("Reconcile error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "results": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// HandleDrift reports drift for one repository (?repo=owner/name&ref=main) or all configured ones.
+// Pass format=text for a human-readable report instead of JSON.
+func HandleDrift(c *gin.Context) {
+	var targets []services.ReconcileTarget
+	if repo := c.Query("repo"); repo != "" {
+		targets = append(targets, services.ReconcileTarget{Repository: repo, Ref: c.Query("ref")})
+	}
+
+	report, err := services.DetectDrift(c.Request.Context(), targets)
+	if err != nil {
+		log.Printf("Drift detection error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "text" {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Status(http.StatusOK)
+		report.WriteText(c.Writer)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// HandleJobs lists recent pipeline run jobs, newest first (?limit=50)
+func HandleJobs(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": services.ListJobs(limit)})
+}
+
+// HandleJob returns a single pipeline run job
+func HandleJob(c *gin.Context) {
+	job, ok := services.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// HandleDeliveries lists recorded webhook deliveries, newest first (?limit=50)
+func HandleDeliveries(c *gin.Context) {
+	db, limit, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	deliveries, err := db.ListDeliveries(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// HandleSyncHistory lists recorded sync attempts, newest first (?path=api_&limit=50)
+func HandleSyncHistory(c *gin.Context) {
+	db, limit, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	attempts, err := db.ListSyncAttempts(c.Query("path"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"syncs": attempts})
+}
+
+// HandlePipelineRuns lists recorded pipeline runs, newest first (?limit=50)
+func HandlePipelineRuns(c *gin.Context) {
+	db, limit, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	runs, err := db.ListPipelineRuns(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// storeQuery returns the configured store and the ?limit parameter, answering the request itself on error
+func storeQuery(c *gin.Context) (*store.Store, int, bool) {
+	db := store.Default()
+	if db == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No store configured, set STORE_PATH"})
+		return nil, 0, false
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return nil, 0, false
+	}
+	return db, limit, true
+}
+
+// HandleAudit lists audit log entries, newest first, filtered by ?type, ?repository, ?path and ?since (RFC 3339)
+func HandleAudit(c *gin.Context) {
+	db, limit, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+	entries, err := db.ListAudit(filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// HandleAuditExport streams the audit log as JSON lines, oldest first, with the same filters as HandleAudit
+func HandleAuditExport(c *gin.Context) {
+	db, _, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	if err := db.ExportAudit(c.Writer, filter); err != nil {
+		log.Printf("Audit export error: %v", err)
+	}
+}
+
+// HandleAuditVerify checks the hash chain of the audit log
+func HandleAuditVerify(c *gin.Context) {
+	db, _, ok := storeQuery(c)
+	if !ok {
+		return
+	}
+	count, err := db.VerifyAudit()
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"valid": false, "entries": count, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true, "entries": count})
+}
+
+// auditFilter reads audit log filters from the query, answering the request itself on error
+func auditFilter(c *gin.Context) (store.AuditFilter, bool) {
+	filter := store.AuditFilter{Type: c.Query("type"), Repository: c.Query("repository"), Path: c.Query("path")}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC 3339"})
+			return filter, false
+		}
+		filter.Since = t
+	}
+	return filter, true
+}
+
+// HandleVersions lists the stored versions of the target given by ?key, as shown in plans, newest first
+func HandleVersions(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+	versions, err := services.ListVersions(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "versions": versions})
+}
+
+// rollbackRequest is the body of a rollback; Version 0 restores the version before the current one
+type rollbackRequest struct {
+	Key     string `json:"key" binding:"required"`
+	Version uint64 `json:"version"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+// rollbackTimeout bounds a rollback, which runs detached from the request
+const rollbackTimeout = 5 * time.Minute
+
+// HandleRollback re-uploads a previous version of a target and triggers its pipelines again
+func HandleRollback(c *gin.Context) {
+	var request rollbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rollback request: " + err.Error()})
+		return
+	}
+	// Secure files are deleted before the upload, so a client disconnecting must not cancel the rollback halfway
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	// The audit log records the operator whose token was used, see RequireAdminToken
+	actor := c.GetString("admin")
+	filePlan, err := services.Rollback(ctx, request.Key, request.Version, actor, services.ProcessOptions{DryRun: request.DryRun})
+	if err != nil {
+		log.Printf("Rollback error for %s: %v", request.Key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "plan": filePlan})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plan": filePlan})
+}
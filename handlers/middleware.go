@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"env-updater/core"
+	"env-updater/store"
+	"github.com/gin-gonic/gin"
+)
+
+// SourceAllowList rejects requests whose client IP is outside the configured CIDR ranges.
+//
+// Ranges come from WEBHOOK_ALLOWED_CIDRS (comma-separated) and/or
+// WEBHOOK_ALLOWED_CIDRS_FILE, which may be a snapshot of https://api.github.com/meta
+// (the "hooks" list is used) or a plain file with one CIDR per line.
+// When neither is set, all sources are allowed. Behind a reverse proxy, set TRUSTED_PROXIES
+// so that the client IP is taken from X-Forwarded-For.
+func SourceAllowList() gin.HandlerFunc {
+	networks, err := loadAllowedNetworks()
+	if err != nil {
+		log.Fatalf("Failed to load webhook source allow-list: %v", err)
+	}
+	if len(networks) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	log.Printf("Webhook source allow-list enabled with %d ranges", len(networks))
+
+	return func(c *gin.Context) {
+		ip := net.ParseIP(c.ClientIP())
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				c.Next()
+				return
+			}
+		}
+
+		log.Printf("Rejected webhook from disallowed source %s", c.ClientIP())
+		core.IncCounter("webhook_rejections", "reason", "source_not_allowed")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Source not allowed"})
+	}
+}
+
+// loadAllowedNetworks parses the CIDR ranges from the environment and the optional snapshot file
+func loadAllowedNetworks() ([]*net.IPNet, error) {
+	cidrs := core.SplitList(os.Getenv("WEBHOOK_ALLOWED_CIDRS"))
+
+	if path := os.Getenv("WEBHOOK_ALLOWED_CIDRS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+
+		var meta struct {
+			Hooks []string `json:"hooks"`
+		}
+		if err := json.Unmarshal(data, &meta); err == nil {
+			cidrs = append(cidrs, meta.Hooks...)
+		} else {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					cidrs = append(cidrs, line)
+				}
+			}
+		}
+	}
+
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ReplayProtection rejects webhook deliveries that were already processed or are too old.
+//
+//   - WEBHOOK_REQUIRE_DELIVERY_ID=true rejects requests without X-GitHub-Delivery, and any
+//     delivery id or payload that was already processed within WEBHOOK_DELIVERY_TTL (default 72h).
+//     The delivery id is not signed, so the payload hash catches a replay under a new id.
+//   - WEBHOOK_MAX_AGE (e.g. "15m") rejects pushes and pull request events whose timestamp is
+//     older than the window, or further in the future than WEBHOOK_MAX_CLOCK_SKEW (default 5m).
+//
+// A delivery is only remembered once the handler accepted it, so unsigned or failed
+// requests cannot block a later legitimate delivery or redelivery.
+func ReplayProtection() gin.HandlerFunc {
+	requireDeliveryID := core.EnvBool("WEBHOOK_REQUIRE_DELIVERY_ID", false)
+	maxAge := core.EnvDuration("WEBHOOK_MAX_AGE", 0)
+	maxSkew := core.EnvDuration("WEBHOOK_MAX_CLOCK_SKEW", 5*time.Minute)
+	deliveries := newDeliveryCache(core.EnvDuration("WEBHOOK_DELIVERY_TTL", 72*time.Hour))
+
+	return func(c *gin.Context) {
+		if maxAge == 0 && !requireDeliveryID {
+			c.Next()
+			return
+		}
+
+		payload, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("Payload read error: %v", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(payload))
+
+		if maxAge > 0 {
+			if err := checkFreshness(payload, maxAge, maxSkew); err != nil {
+				log.Printf("Rejected stale webhook %s: %v", c.GetHeader("X-GitHub-Delivery"), err)
+				core.IncCounter("webhook_rejections", "reason", "stale")
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Stale webhook"})
+				return
+			}
+		}
+
+		if !requireDeliveryID {
+			c.Next()
+			return
+		}
+
+		deliveryID := c.GetHeader("X-GitHub-Delivery")
+		if deliveryID == "" {
+			core.IncCounter("webhook_rejections", "reason", "missing_delivery_id")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Missing delivery id"})
+			return
+		}
+
+		payloadHash := core.ContentHash(payload)
+		c.Set("payload_hash", payloadHash)
+
+		// Processed before a restart, the in-memory cache no longer knows it
+		processed, err := store.Default().HasDelivery(deliveryID)
+		if err == nil && !processed {
+			processed, err = store.Default().HasPayload(payloadHash)
+		}
+		if err != nil {
+			log.Printf("Failed to look up delivery %s: %v", deliveryID, err)
+		}
+
+		payloadKey := "sha256:" + payloadHash
+		if processed || !deliveries.reserve(deliveryID) {
+			rejectReplay(c, deliveryID)
+			return
+		}
+		if !deliveries.reserve(payloadKey) {
+			deliveries.release(deliveryID)
+			rejectReplay(c, deliveryID)
+			return
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		for _, key := range []string{deliveryID, payloadKey} {
+			if status >= 200 && status < 300 {
+				deliveries.confirm(key)
+			} else {
+				deliveries.release(key)
+			}
+		}
+	}
+}
+
+// rejectReplay answers a delivery whose id or payload was already processed
+func rejectReplay(c *gin.Context, deliveryID string) {
+	log.Printf("Rejected replayed webhook delivery %s", deliveryID)
+	core.IncCounter("webhook_rejections", "reason", "replay")
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Delivery already processed"})
+}
+
+// checkFreshness verifies that the push's head_commit timestamp, or the pull request's
+// updated_at, is within the allowed window. Events with neither (ping, branch deletion)
+// change nothing and are not checked.
+func checkFreshness(payload []byte, maxAge, maxSkew time.Duration) error {
+	var event struct {
+		HeadCommit *struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"head_commit"`
+		PullRequest *struct {
+			UpdatedAt string `json:"updated_at"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("invalid payload: %v", err)
+	}
+
+	var field, value string
+	switch {
+	case event.HeadCommit != nil && event.HeadCommit.Timestamp != "":
+		field, value = "head_commit timestamp", event.HeadCommit.Timestamp
+	case event.PullRequest != nil && event.PullRequest.UpdatedAt != "":
+		field, value = "pull_request updated_at", event.PullRequest.UpdatedAt
+	default:
+		return nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", field, value, err)
+	}
+
+	age := time.Since(timestamp)
+	if age > maxAge {
+		return fmt.Errorf("%s is %s old, maximum is %s", field, age.Round(time.Second), maxAge)
+	}
+	if age < -maxSkew {
+		return fmt.Errorf("%s is %s in the future", field, (-age).Round(time.Second))
+	}
+	return nil
+}
+
+// deliveryCache remembers processed delivery ids until they expire
+type deliveryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	seen    map[string]time.Time // delivery id -> expiry
+	pending map[string]bool      // delivery ids currently being processed
+}
+
+func newDeliveryCache(ttl time.Duration) *deliveryCache {
+	return &deliveryCache{
+		ttl:     ttl,
+		seen:    make(map[string]time.Time),
+		pending: make(map[string]bool),
+	}
+}
+
+// reserve marks a delivery as in progress, returning false if it was already seen
+func (d *deliveryCache) reserve(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for seenID, expiry := range d.seen {
+		if now.After(expiry) {
+			delete(d.seen, seenID)
+		}
+	}
+
+	if _, ok := d.seen[id]; ok || d.pending[id] {
+		return false
+	}
+	d.pending[id] = true
+	return true
+}
+
+// confirm records a delivery as processed
+func (d *deliveryCache) confirm(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, id)
+	d.seen[id] = time.Now().Add(d.ttl)
+}
+
+// release forgets a reserved delivery so it can be retried
+func (d *deliveryCache) release(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, id)
+}
+
+// RecordDeliveries stores every webhook delivery whose signature was verified, with the
+// status it was answered with. Unsigned requests are not stored, so they cannot fill the store.
+// Handlers add details with c.Set("verified", true), c.Set("repository", ...) and c.Set("files", ...).
+func RecordDeliveries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		db := store.Default()
+		if db == nil || !c.GetBool("verified") {
+			return
+		}
+		delivery := store.Delivery{
+			Id:         c.GetHeader("X-GitHub-Delivery"),
+			Time:       time.Now().UTC(),
+			Event:      c.GetHeader("X-GitHub-Event"),
+			Repository: c.GetString("repository"),
+			Status:     c.Writer.Status(),
+			Files:      c.GetInt("files"),
+
+			PayloadHash: c.GetString("payload_hash"),
+		}
+		if delivery.Id == "" {
+			delivery.Id = "unidentified-" + delivery.Time.Format(time.RFC3339Nano)
+		}
+		if len(c.Errors) > 0 {
+			delivery.Error = c.Errors.String()
+		}
+		if err := db.SaveDelivery(delivery); err != nil {
+			log.Printf("Failed to record delivery %s: %v", delivery.Id, err)
+		}
+	}
+}
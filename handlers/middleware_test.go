@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeliveryCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		setup func(d *deliveryCache)
+		want  bool // a new reservation of "d1" succeeds
+	}{
+		{name: "unknown delivery", ttl: time.Hour, setup: func(d *deliveryCache) {}, want: true},
+		{name: "delivery in progress", ttl: time.Hour, setup: func(d *deliveryCache) { d.reserve("d1") }, want: false},
+		{name: "processed delivery", ttl: time.Hour, setup: func(d *deliveryCache) { d.reserve("d1"); d.confirm("d1") }, want: false},
+		{name: "released delivery can be retried", ttl: time.Hour, setup: func(d *deliveryCache) { d.reserve("d1"); d.release("d1") }, want: true},
+		{name: "processed delivery past the TTL", ttl: -time.Second, setup: func(d *deliveryCache) { d.reserve("d1"); d.confirm("d1") }, want: true},
+		{name: "other delivery", ttl: time.Hour, setup: func(d *deliveryCache) { d.reserve("d2"); d.confirm("d2") }, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries := newDeliveryCache(tt.ttl)
+			tt.setup(deliveries)
+			if got := deliveries.reserve("d1"); got != tt.want {
+				t.Errorf("reserve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("WEBHOOK_REQUIRE_DELIVERY_ID", "true")
+
+	// The handler fails any payload containing "fail", as a handler rejecting a bad signature would
+	router := gin.New()
+	router.POST("/webhook", ReplayProtection(), func(c *gin.Context) {
+		body, _ := c.GetRawData()
+		if strings.Contains(string(body), "fail") {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	send := func(id, payload string) int {
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader(payload))
+		if id != "" {
+			req.Header.Set("X-GitHub-Delivery", id)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	steps := []struct {
+		name    string
+		id      string
+		payload string
+		want    int
+	}{
+		{name: "first delivery", id: "d1", payload: `{"n":1}`, want: http.StatusOK},
+		{name: "same delivery id", id: "d1", payload: `{"n":2}`, want: http.StatusConflict},
+		{name: "same payload under a new id", id: "d2", payload: `{"n":1}`, want: http.StatusConflict},
+		{name: "missing delivery id", id: "", payload: `{"n":3}`, want: http.StatusBadRequest},
+		{name: "failed delivery", id: "d3", payload: `{"fail":true}`, want: http.StatusUnauthorized},
+		{name: "failed delivery id is not remembered", id: "d3", payload: `{"n":4}`, want: http.StatusOK},
+		{name: "failed payload is not remembered", id: "d4", payload: `{"fail":true}`, want: http.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		if got := send(step.id, step.payload); got != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{name: "recent push", payload: `{"head_commit":{"timestamp":"` + now.Add(-time.Minute).Format(time.RFC3339) + `"}}`},
+		{name: "old push", payload: `{"head_commit":{"timestamp":"` + now.Add(-time.Hour).Format(time.RFC3339) + `"}}`, wantErr: "old"},
+		{name: "push from the future", payload: `{"head_commit":{"timestamp":"` + now.Add(time.Hour).Format(time.RFC3339) + `"}}`, wantErr: "in the future"},
+		{name: "old pull request", payload: `{"pull_request":{"updated_at":"` + now.Add(-time.Hour).Format(time.RFC3339) + `"}}`, wantErr: "pull_request updated_at"},
+		{name: "event without a timestamp", payload: `{"zen":"hi"}`},
+		{name: "invalid timestamp", payload: `{"head_commit":{"timestamp":"yesterday"}}`, wantErr: "invalid head_commit timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFreshness([]byte(tt.payload), 15*time.Minute, 5*time.Minute)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkFreshness() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkFreshness() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
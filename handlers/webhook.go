@@ -2,12 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"env-updater/core"
+	"env-updater/services"
+	"github.com/gin-gonic/gin"
 	"io"
 	"log"
 	"net/http"
-    "github.com/gin-gonic/gin"
-	"env-updater/core"
-	"env-updater/services"
 )
 
 func HandleWebhook(c *gin.Context) {
@@ -19,11 +19,21 @@ func HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Verify webhook signature
-	if !core.VerifyWebhookSignature(payload, c.GetHeader("X-Hub-Signature-256")) {
+	// Verify webhook signature against the secrets configured for the repository
+	signatures := core.WebhookSignatures{
+		SHA256: c.GetHeader("X-Hub-Signature-256"),
+		SHA1:   c.GetHeader("X-Hub-Signature"),
+	}
+	repoFullName := repositoryFullName(payload)
+	c.Set("repository", repoFullName)
+	match, ok := core.VerifyWebhookRequest(payload, signatures, repoFullName)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized webhook"})
 		return
 	}
+	c.Set("verified", true)
+	log.Printf("Webhook %s for %s verified with secret %s (%s)",
+		c.GetHeader("X-GitHub-Delivery"), repoFullName, match.SecretID, match.Algorithm)
 
 	// Parse webhook payload
 	var webhookData map[string]interface{}
@@ -33,13 +43,70 @@ func HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Process webhook
-	if err := services.ProcessWebhookEvent(webhookData); err != nil {
+	event := c.GetHeader("X-GitHub-Event")
+	switch event {
+	case "ping":
+		c.JSON(http.StatusOK, gin.H{"status": "pong"})
+		return
+	case "pull_request":
+		plan, err := services.ProcessPullRequestEvent(webhookData)
+		if err != nil {
+			log.Printf("Pull request processing error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Processing failed"})
+			return
+		}
+		if plan == nil {
+			c.JSON(http.StatusAccepted, gin.H{"status": "Pull request action ignored"})
+			return
+		}
+		c.Set("files", len(plan.Files))
+		c.JSON(http.StatusOK, gin.H{"status": "Pull request plan posted", "plan": plan})
+		return
+	case "", "push":
+		// Processed below
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"status": "Event " + event + " ignored"})
+		return
+	}
+
+	// Process webhook, optionally as a dry run (?dry_run=true) or re-uploading unchanged files (?force=true)
+	opts := services.ProcessOptions{DryRun: c.Query("dry_run") == "true", Force: c.Query("force") == "true"}
+	plan, err := services.ProcessWebhookEvent(webhookData, opts)
+	if err != nil {
 		log.Printf("Webhook processing error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Processing failed"})
 		return
 	}
 
-	// Respond with success
-	c.JSON(http.StatusOK, gin.H{"status": "Webhook processed successfully"})
-}
\ No newline at end of file
+	c.Set("files", len(plan.Files))
+
+	// Respond with success, including the resolved plan
+	status := "Webhook processed successfully"
+	if plan.DryRun {
+		status = "Dry run completed, no changes applied"
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status, "plan": plan})
+}
+
+// repositoryFullName extracts repository.full_name from an unverified payload so
+// that repository-specific secrets can be selected. It returns "" when absent.
+func repositoryFullName(payload []byte) string {
+	var envelope struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return ""
+	}
+	return envelope.Repository.FullName
+}
+
+// HandleMetrics exposes in-process counters in the Prometheus text format
+func HandleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4")
+	c.Status(http.StatusOK)
+	if err := core.WriteMetrics(c.Writer); err != nil {
+		log.Printf("Metrics write error: %v", err)
+	}
+}
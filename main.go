@@ -1,21 +1,92 @@
 package main
 
 import (
+	"context"
+	"env-updater/cli"
+	"env-updater/core"
+	"env-updater/handlers"
+	"env-updater/services"
+	"env-updater/store"
+	"github.com/gin-gonic/gin"
 	"log"
 	"os"
-    "github.com/gin-gonic/gin"
-	"env-updater/handlers"
+	"time"
 )
 
 func main() {
 	// Configure logging
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	// Open the state store when configured. The store file is locked while open, so CLI
+	// commands run next to the server reach its store through the admin API (ADMIN_URL).
+	serving := len(os.Args) < 2 || os.Args[1] == "serve"
+	usesAdminAPI := !serving && cli.UsesAdminAPI(os.Args[1:])
+	if path := os.Getenv("STORE_PATH"); path != "" && !usesAdminAPI {
+		db, err := store.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open store, if the server is running set ADMIN_URL to use its admin API: %v", err)
+		}
+		defer db.Close()
+		store.SetDefault(db)
+
+		// Authenticate the audit log so that an edited entry cannot be rehashed without the key
+		db.SetAuditKey([]byte(os.Getenv("AUDIT_HMAC_KEY")))
+		if serving && os.Getenv("AUDIT_HMAC_KEY") == "" {
+			log.Printf("AUDIT_HMAC_KEY is not set, anyone who can write the store can rewrite the audit log undetected")
+		}
+		if serving {
+			db.StartPruneLoop(context.Background(), core.EnvDuration("STORE_RETENTION", 30*24*time.Hour))
+		}
+	}
+
+	// Hashes recorded in sinks are keyed, and must stay comparable across restarts and CLI runs
+	if os.Getenv("CONTENT_HASH_KEY") == "" && !usesAdminAPI && (serving || !cli.IsHelp(os.Args[1:])) {
+		log.Fatalf("Missing environment variable: CONTENT_HASH_KEY (any secret string, keep it stable)")
+	}
+
+	// Run a CLI subcommand unless asked to serve webhooks
+	if !serving {
+		if err := cli.Run(os.Args[1:]); err != nil {
+			store.Default().Close()
+			log.Fatalf("%s failed: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	// Create Gin router
 	router := gin.Default()
 
+	// Only take the client IP from X-Forwarded-For when set by a proxy in TRUSTED_PROXIES
+	// (comma-separated IPs or CIDRs, default none), so the source allow-list cannot be spoofed
+	if err := router.SetTrustedProxies(core.SplitList(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Register webhook endpoint
-	router.POST("/webhook", handlers.HandleWebhook)
+	router.POST("/webhook", handlers.SourceAllowList(), handlers.ReplayProtection(), handlers.RecordDeliveries(), handlers.HandleWebhook)
+
+	// Register metrics endpoint
+	router.GET("/metrics", handlers.HandleMetrics)
+
+	// Register admin endpoints
+	admin := router.Group("/admin", handlers.RequireAdminToken())
+	admin.POST("/reconcile", handlers.HandleReconcile)
+	admin.GET("/drift", handlers.HandleDrift)
+	admin.GET("/jobs", handlers.HandleJobs)
+	admin.GET("/jobs/:id", handlers.HandleJob)
+	admin.GET("/deliveries", handlers.HandleDeliveries)
+	admin.GET("/syncs", handlers.HandleSyncHistory)
+	admin.GET("/runs", handlers.HandlePipelineRuns)
+	admin.GET("/audit", handlers.HandleAudit)
+	admin.GET("/audit/export", handlers.HandleAuditExport)
+	admin.GET("/audit/verify", handlers.HandleAuditVerify)
+	admin.GET("/versions", handlers.HandleVersions)
+	admin.POST("/rollback", handlers.HandleRollback)
+
+	// Schedule periodic reconciliation
+	if interval := core.EnvDuration("RECONCILE_INTERVAL", 0); interval > 0 {
+		services.StartReconcileLoop(context.Background(), interval)
+	}
 
 	// Determine server port
 	port := os.Getenv("PORT")
@@ -28,4 +99,4 @@ func main() {
 	if err := router.Run(":" + port); err != nil {
 		log.Fatalf("Server startup failed: %v", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"env-updater/sinks"
+	"env-updater/store"
+)
+
+// audit appends an entry to the audit log, filling in the source of the change
+func audit(source sinks.Source, entry store.AuditEntry) {
+	entry.Actor = source.Actor
+	entry.Repository = source.Repository
+	entry.SHA = source.SHA
+	if entry.SHA == "" {
+		entry.SHA = source.Ref
+	}
+	entry.Path = source.Path
+
+	db := store.Default()
+	if db == nil {
+		return
+	}
+	if _, err := db.AppendAudit(entry); err != nil {
+		log.Printf("Failed to append %s audit entry for %s: %v", entry.Type, source.Path, err)
+	}
+}
+
+// auditTarget fills in where an entry's change was written, including the backend's id of the object
+func auditTarget(ctx context.Context, entry store.AuditEntry, route Route, sink sinks.Sink, name string) store.AuditEntry {
+	entry.Sink = sink.Kind()
+	entry.Location = sink.Location()
+	if route.SinkName() == SinkSecureFile || route.SinkName() == SinkVariableGroup {
+		entry.Location = os.Getenv("AZURE_DEVOPS_ORG") + "/" + entry.Location
+	}
+	entry.Target = sink.Describe(name)
+
+	// Looking up the id costs a request, skip it when there is no audit log to write to
+	if identifier, ok := sink.(sinks.Identifier); ok && store.Default() != nil {
+		id, err := identifier.ObjectId(ctx, name)
+		if err != nil {
+			log.Printf("Failed to look up the id of %s for the audit log: %v", entry.Target, err)
+		}
+		entry.ObjectId = id
+	}
+	return entry
+}
+
+// auditKeys converts a key diff to the key names recorded in the audit log
+func auditKeys(diff *KeyDiff) *store.AuditKeys {
+	if diff == nil {
+		return nil
+	}
+	keys := &store.AuditKeys{}
+	for _, change := range diff.Added {
+		keys.Added = append(keys.Added, change.Key)
+	}
+	for _, change := range diff.Removed {
+		keys.Removed = append(keys.Removed, change.Key)
+	}
+	for _, change := range diff.Changed {
+		keys.Changed = append(keys.Changed, change.Key)
+	}
+	return keys
+}
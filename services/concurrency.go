@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"env-updater/core"
+	"env-updater/sinks"
+	"env-updater/store"
+)
+
+// orgLimiters bounds the files processed at the same time against each Azure DevOps
+// organization, or each sink kind for routes without an Azure project
+var orgLimiters = struct {
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}{sems: map[string]chan struct{}{}}
+
+// acquireOrgSlot waits for one of SYNC_CONCURRENCY (default 4) slots for the key and returns
+// the function releasing it
+func acquireOrgSlot(ctx context.Context, key string) (func(), error) {
+	orgLimiters.mu.Lock()
+	sem, ok := orgLimiters.sems[key]
+	if !ok {
+		limit := core.EnvInt("SYNC_CONCURRENCY", 4)
+		if limit < 1 {
+			limit = 1
+		}
+		sem = make(chan struct{}, limit)
+		orgLimiters.sems[key] = sem
+	}
+	orgLimiters.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// limiterKey returns the key a route's files are limited by
+func limiterKey(route Route) string {
+	if route.Project != "" {
+		return "azure:" + os.Getenv("AZURE_DEVOPS_ORG")
+	}
+	return "sink:" + route.SinkName()
+}
+
+// targetLocks serializes writes to the same target, so that concurrent deliveries
+// never interleave the delete and upload of one secure file
+var targetLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the function unlocking it. Unused keys are dropped.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		k.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// appliedCommits remembers the commit last written to each target, see isStaleSource
+var appliedCommits = struct {
+	mu   sync.Mutex
+	shas map[string]string
+}{shas: map[string]string{}}
+
+// recordAppliedCommit remembers that source was written to target
+func recordAppliedCommit(target string, source sinks.Source) {
+	if source.SHA == "" {
+		return
+	}
+	appliedCommits.mu.Lock()
+	defer appliedCommits.mu.Unlock()
+	appliedCommits.shas[target] = source.Repository + "@" + source.SHA
+}
+
+// isStaleSource reports whether source is an ancestor of the commit last written to target,
+// so that a delivery processed late cannot overwrite newer content. Callers hold the target lock.
+func isStaleSource(ctx context.Context, target string, source sinks.Source) (bool, string) {
+	if source.SHA == "" {
+		return false, "" // explicit syncs and rollbacks always apply
+	}
+
+	appliedCommits.mu.Lock()
+	applied := appliedCommits.shas[target]
+	appliedCommits.mu.Unlock()
+	if applied == "" {
+		if stored, ok, _ := store.Default().GetContentHash(target); ok && stored.SHA != "" {
+			applied = stored.Repository + "@" + stored.SHA
+		}
+	}
+
+	repository, sha, _ := strings.Cut(applied, "@")
+	if sha == "" || repository != source.Repository || sha == source.SHA {
+		return false, ""
+	}
+	status, err := core.CompareCommits(ctx, source.Repository, sha, source.SHA)
+	if err != nil {
+		log.Printf("Failed to compare %.7s with the last applied %.7s for %s, writing anyway: %v", source.SHA, sha, target, err)
+		return false, ""
+	}
+	return status == "behind", sha
+}
+
+// forEachConcurrently calls fn for every index up to n with at most limit calls running at once
+func forEachConcurrently(n, limit int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"env-updater/core"
+	"env-updater/sinks"
+	"github.com/joho/godotenv"
+)
+
+// KeyDiff is a key-level comparison of two versions of an env file.
+// Values never appear in clear: they are hashed or left out depending on DIFF_VALUES.
+type KeyDiff struct {
+	From    string      `json:"from,omitempty"` // commit the previous version was read from, empty for a new file
+	Added   []KeyChange `json:"added,omitempty"`
+	Removed []KeyChange `json:"removed,omitempty"`
+	Changed []KeyChange `json:"changed,omitempty"`
+}
+
+// KeyChange is a key whose presence or value changed
+type KeyChange struct {
+	Key    string `json:"key"`
+	Before string `json:"before,omitempty"` // value fingerprint when DIFF_VALUES=hash
+	After  string `json:"after,omitempty"`
+}
+
+// Empty reports whether no key changed
+func (d *KeyDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+// String summarizes the diff in one line, e.g. "+NEW_KEY ~DB_URL -OLD_KEY"
+func (d *KeyDiff) String() string {
+	if d.Empty() {
+		return "no key changes"
+	}
+	var parts []string
+	for _, change := range d.Added {
+		parts = append(parts, "+"+change.Key)
+	}
+	for _, change := range d.Changed {
+		parts = append(parts, "~"+change.Key)
+	}
+	for _, change := range d.Removed {
+		parts = append(parts, "-"+change.Key)
+	}
+	return strings.Join(parts, " ")
+}
+
+// DiffEnv compares two parsed env files key by key. With hashValues set, each change
+// carries a short fingerprint of the values so that reviewers can tell values apart.
+// Fingerprints are keyed with core.TargetHash, so that guessed values cannot be confirmed.
+func DiffEnv(before, after map[string]string, hashValues bool) *KeyDiff {
+	fingerprint := func(key, value string) string {
+		if !hashValues {
+			return ""
+		}
+		return "hmac:" + core.TargetHash([]byte(key + "=" + value))[:12]
+	}
+
+	diff := &KeyDiff{}
+	for key, value := range after {
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, KeyChange{Key: key, After: fingerprint(key, value)})
+		case previous != value:
+			diff.Changed = append(diff.Changed, KeyChange{Key: key, Before: fingerprint(key, previous), After: fingerprint(key, value)})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, KeyChange{Key: key, Before: fingerprint(key, value)})
+		}
+	}
+
+	for _, changes := range [][]KeyChange{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	}
+	return diff
+}
+
+// diffWithPrevious compares content with the version of the file at the source's parent commit.
+// It returns nil when there is no commit to compare with.
+func diffWithPrevious(ctx context.Context, source sinks.Source, content []byte) (*KeyDiff, error) {
+	parent := source.Parent
+	if parent == "" || strings.Trim(parent, "0") == "" {
+		if source.SHA == "" {
+			return nil, nil
+		}
+		var err error
+		if parent, err = core.CommitParent(ctx, source.Repository, source.SHA); err != nil {
+			return nil, err
+		}
+	}
+
+	after, err := godotenv.Unmarshal(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source.Path, err)
+	}
+
+	before := map[string]string{}
+	if parent != "" {
+		previous, err := core.FetchFileFromGitHubAtRef(ctx, source.Repository, source.Path, parent)
+		switch {
+		case errors.Is(err, core.ErrFileNotFound):
+			// A new file, every key is added
+		case err != nil:
+			return nil, err
+		default:
+			if before, err = godotenv.Unmarshal(string(previous)); err != nil {
+				// The previous version may have been invalid, compare against nothing
+				before = map[string]string{}
+			}
+		}
+	}
+
+	diff := DiffEnv(before, after, os.Getenv("DIFF_VALUES") == "hash")
+	diff.From = parent
+	return diff, nil
+}
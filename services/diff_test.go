@@ -0,0 +1,74 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDiffEnv(t *testing.T) {
+	t.Setenv("CONTENT_HASH_KEY", "test-key")
+
+	before := map[string]string{"KEEP": "1", "CHANGE": "old", "REMOVE": "x"}
+	after := map[string]string{"KEEP": "1", "CHANGE": "new", "ADD": "y", "ADD_TOO": "z"}
+
+	tests := []struct {
+		name       string
+		hashValues bool
+	}{
+		{name: "keys only", hashValues: false},
+		{name: "with value fingerprints", hashValues: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffEnv(before, after, tt.hashValues)
+			if got := diff.String(); got != "+ADD +ADD_TOO ~CHANGE -REMOVE" {
+				t.Errorf("diff = %q", got)
+			}
+
+			changed := diff.Changed[0]
+			if !tt.hashValues {
+				if changed.Before != "" || changed.After != "" || diff.Added[0].After != "" {
+					t.Errorf("fingerprints set without hashValues: %+v", diff)
+				}
+				return
+			}
+			if !strings.HasPrefix(changed.Before, "hmac:") || changed.Before == changed.After {
+				t.Errorf("changed = %+v, want distinct hmac fingerprints", changed)
+			}
+			if diff.Removed[0].Before == "" || diff.Added[0].Before != "" {
+				t.Errorf("added = %+v, removed = %+v", diff.Added[0], diff.Removed[0])
+			}
+		})
+	}
+}
+
+func TestDiffEnvFingerprintIsKeyed(t *testing.T) {
+	values := map[string]string{"PASSWORD": "hunter2"}
+
+	t.Setenv("CONTENT_HASH_KEY", "first-key")
+	first := DiffEnv(nil, values, true).Added[0].After
+	if again := DiffEnv(nil, values, true).Added[0].After; again != first {
+		t.Errorf("fingerprint is not stable: %q then %q", first, again)
+	}
+
+	// An unkeyed hash of a guessed value must not match the published fingerprint
+	sum := sha256.Sum256([]byte("PASSWORD=hunter2"))
+	if strings.Contains(first, hex.EncodeToString(sum[:6])) {
+		t.Errorf("fingerprint %q is an unkeyed sha256", first)
+	}
+
+	t.Setenv("CONTENT_HASH_KEY", "second-key")
+	if other := DiffEnv(nil, values, true).Added[0].After; other == first {
+		t.Errorf("fingerprint %q does not depend on CONTENT_HASH_KEY", other)
+	}
+}
+
+func TestKeyDiffEmpty(t *testing.T) {
+	diff := DiffEnv(map[string]string{"A": "1"}, map[string]string{"A": "1"}, true)
+	if !diff.Empty() || diff.String() != "no key changes" {
+		t.Errorf("diff of identical files = %q", diff)
+	}
+}
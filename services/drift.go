@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"env-updater/core"
+	"env-updater/sinks"
+)
+
+// DriftReport compares routed repository files with the secure files in each Azure project
+type DriftReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Sources     []string       `json:"sources"` // repositories scanned, as owner/repo@ref
+	Projects    []ProjectDrift `json:"projects"`
+}
+
+// ProjectDrift groups the drift found in one Azure DevOps project, or sink location for other sinks
+type ProjectDrift struct {
+	Project  string       `json:"project"`
+	InSync   []DriftEntry `json:"in_sync"`
+	Differs  []DriftEntry `json:"differs"`  // content hash does not match, or was never recorded
+	Missing  []DriftEntry `json:"missing"`  // in the repository but never uploaded
+	Orphaned []DriftEntry `json:"orphaned"` // in Azure with no source in any scanned repository
+	Errors   []DriftEntry `json:"errors,omitempty"`
+}
+
+// DriftEntry describes a single secure file in a drift report
+type DriftEntry struct {
+	SecureFileName string `json:"secure_file_name"` // object name in the sink
+	Target         string `json:"target,omitempty"`
+	Repository     string `json:"repository,omitempty"`
+	Path           string `json:"path,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	RepoHash       string `json:"repo_hash,omitempty"`
+	AzureHash      string `json:"azure_hash,omitempty"` // hash recorded in the sink
+}
+
+// HasDrift reports whether any project differs from its sources
+func (r *DriftReport) HasDrift() bool {
+	for _, project := range r.Projects {
+		if len(project.Differs)+len(project.Missing)+len(project.Orphaned)+len(project.Errors) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes a human-readable version of the report
+func (r *DriftReport) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Drift report generated %s\n", r.GeneratedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Sources: %v\n", r.Sources)
+
+	for _, project := range r.Projects {
+		fmt.Fprintf(w, "\nProject %s: %d in sync, %d differ, %d missing, %d orphaned\n",
+			project.Project, len(project.InSync), len(project.Differs), len(project.Missing), len(project.Orphaned))
+
+		for _, entry := range project.Differs {
+			fmt.Fprintf(w, "  ~ %s (%s from %s:%s)\n", entry.SecureFileName, entry.Reason, entry.Repository, entry.Path)
+		}
+		for _, entry := range project.Missing {
+			fmt.Fprintf(w, "  + %s (not uploaded, from %s:%s)\n", entry.SecureFileName, entry.Repository, entry.Path)
+		}
+		for _, entry := range project.Orphaned {
+			fmt.Fprintf(w, "  - %s (no source in GitHub)\n", entry.Target)
+		}
+		for _, entry := range project.Errors {
+			fmt.Fprintf(w, "  ! %s (%s)\n", entry.SecureFileName, entry.Reason)
+		}
+	}
+
+	if !r.HasDrift() {
+		fmt.Fprintln(w, "\nNo drift detected.")
+	}
+	return nil
+}
+
+// DetectDrift builds a drift report for the given repositories, defaulting to RECONCILE_REPOS
+func DetectDrift(ctx context.Context, targets []ReconcileTarget) (*DriftReport, error) {
+	if len(targets) == 0 {
+		targets = ReconcileTargets()
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no repositories given and none configured in RECONCILE_REPOS")
+	}
+
+	report := &DriftReport{GeneratedAt: time.Now().UTC()}
+	projects := map[string]*ProjectDrift{}
+	listers := map[string]sinks.Sink{}      // sink kind and location -> sink that can list its objects
+	sourced := map[string]map[string]bool{} // sink kind and location -> object names with a source
+
+	for _, target := range targets {
+		ref := target.Ref
+		if ref == "" {
+			ref = core.DefaultRef()
+		}
+		report.Sources = append(report.Sources, target.Repository+"@"+ref)
+
+		routedFiles, err := collectRoutedFiles(ctx, target.Repository, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, routed := range routedFiles {
+			location := routed.Route.Project
+			if routed.Sink != nil {
+				location = routed.Sink.Location()
+			}
+			if projects[location] == nil {
+				projects[location] = &ProjectDrift{Project: location}
+			}
+			drift := projects[location]
+
+			entry := DriftEntry{
+				SecureFileName: routed.Name(),
+				Target:         routed.Target(),
+				Repository:     target.Repository,
+				Path:           routed.Path,
+				RepoHash:       routed.Hash,
+			}
+			if routed.Err != nil {
+				entry.Reason = routed.Err.Error()
+				drift.Errors = append(drift.Errors, entry)
+				continue
+			}
+
+			sinkKey := routed.Sink.Kind() + ":" + location
+			if sourced[sinkKey] == nil {
+				sourced[sinkKey] = map[string]bool{}
+			}
+			sourced[sinkKey][routed.Name()] = true
+			if _, ok := routed.Sink.(sinks.Lister); ok {
+				listers[sinkKey] = routed.Sink
+			}
+
+			recorded, exists, err := routed.Sink.Hash(ctx, routed.Name())
+			if err != nil {
+				entry.Reason = err.Error()
+				drift.Errors = append(drift.Errors, entry)
+				continue
+			}
+			entry.AzureHash = recorded
+
+			switch entry.Reason = driftReason(recorded, exists, routed.Hash); entry.Reason {
+			case "":
+				drift.InSync = append(drift.InSync, entry)
+			case "missing":
+				drift.Missing = append(drift.Missing, entry)
+			default:
+				drift.Differs = append(drift.Differs, entry)
+			}
+		}
+	}
+
+	for sinkKey, sink := range listers {
+		names, err := sink.(sinks.Lister).List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		drift := projects[sink.Location()]
+		for _, name := range names {
+			if !sourced[sinkKey][name] {
+				drift.Orphaned = append(drift.Orphaned, DriftEntry{SecureFileName: name, Target: sink.Describe(name)})
+			}
+		}
+	}
+
+	for _, drift := range projects {
+		sort.Slice(drift.Orphaned, func(i, j int) bool {
+			return drift.Orphaned[i].SecureFileName < drift.Orphaned[j].SecureFileName
+		})
+		report.Projects = append(report.Projects, *drift)
+	}
+	sort.Slice(report.Projects, func(i, j int) bool {
+		return report.Projects[i].Project < report.Projects[j].Project
+	})
+
+	return report, nil
+}
+
+// routedFile is a repository file that matches an explicit route, with its fetched content
+type routedFile struct {
+	Path    string
+	Route   Route
+	Sink    sinks.Sink
+	Content []byte
+	Hash    string
+	Err     error // set when the content could not be fetched or the sink could not be created
+}
+
+// Name is the object name the file is stored under in its sink
+func (f routedFile) Name() string {
+	return filepath.Base(f.Path)
+}
+
+// Target describes where the file is stored
+func (f routedFile) Target() string {
+	if f.Sink == nil {
+		return f.Name()
+	}
+	return f.Sink.Describe(f.Name())
+}
+
+// collectRoutedFiles lists a repository tree at ref and fetches every file matching an explicit route
+func collectRoutedFiles(ctx context.Context, repoFullName, ref string) ([]routedFile, error) {
+	repoFiles, err := core.ListRepositoryFiles(ctx, repoFullName, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var routedFiles []routedFile
+	for _, repoFile := range repoFiles {
+		if isSchemaFile(repoFile.Path) {
+			continue
+		}
+		route, ok, err := matchRoute(filepath.Base(repoFile.Path))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		routed := routedFile{Path: repoFile.Path, Route: route}
+		if routed.Sink, routed.Err = newSink(ctx, route); routed.Err != nil {
+			routed.Sink = nil
+			routedFiles = append(routedFiles, routed)
+			continue
+		}
+		routed.Content, routed.Err = core.FetchFileFromGitHubAtRef(ctx, repoFullName, repoFile.Path, ref)
+		if routed.Err == nil {
+			routed.Hash = core.TargetHash(routed.Content)
+		}
+		routedFiles = append(routedFiles, routed)
+	}
+	return routedFiles, nil
+}
@@ -1,337 +1,789 @@
 package services
 
 import (
-    "bytes"
-    "context"
-    "encoding/base64"
-    "encoding/json"
-    "fmt"
-    "io"
-    "log"
-    "net/http"
-    "os"
-    "path/filepath"
-    "env-updater/core"
-    "time"
-    "github.com/joho/godotenv"
-    "strings"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"env-updater/core"
+	"env-updater/sinks"
+	"env-updater/store"
+	"fmt"
+	"github.com/joho/godotenv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // LoadEnv loads environment variables from a .env file
 func init() {
-    err := godotenv.Load()
-    if err != nil {
-        log.Println("No .env file found. Proceeding with system environment variables...")
-    }
+	err := godotenv.Load()
+	if err != nil {
+		log.Println("No .env file found. Proceeding with system environment variables...")
+	}
 }
 
-// This function should map filenames or parts of filenames to Azure DevOps projects
-func getProjectForFile(filename string) string {
-    // projectfile mapping:
-    projectMap := map[string]string{
-        "frontend_": "gamepride-frontend",
-        "api_":      "gamepride-api",
-        "admin_":    "gamepride-admin",
-    }
-
-    for prefix, project := range projectMap {
-        if strings.HasPrefix(filename, prefix) {
-            return project
-        }
-    }
-    // Default project if no match is found
-    return "DefaultProject"
+// triggerCIByMatchablePart searches for a pipeline with the most matching letters in the string after the last dot
+// of the filename, authorizes it on the file's target and queues it in batch
+func triggerCIByMatchablePart(ctx context.Context, batch *triggerBatch, route Route, sink sinks.Sink, obj sinks.Object, matchPart string) (*PipelineMatch, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	project := route.Project
+
+	if project == "" {
+		return nil, nil // Routes without an Azure project have no pipelines to trigger
+	}
+	if pat == "" || org == "" {
+		return nil, fmt.Errorf("missing environment variables: AZURE_DEVOPS_PAT, AZURE_DEVOPS_ORG, or project")
+	}
+
+	bestMatch, err := findBestPipeline(ctx, pat, org, project, matchPart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set permissions for the pipelines on the target before triggering
+	pipelineIds := append([]int{}, route.Pipelines...)
+	if bestMatch != nil {
+		pipelineIds = append(pipelineIds, bestMatch.Id)
+	}
+	if len(pipelineIds) > 0 {
+		permissionsEntry := auditTarget(ctx, store.AuditEntry{Type: "permissions", Pipelines: pipelineIds}, route, sink, obj.Name)
+		if err := sink.Authorize(ctx, obj.Name, pipelineIds); err != nil {
+			permissionsEntry.Error = err.Error()
+			audit(obj.Source, permissionsEntry)
+			return nil, fmt.Errorf("failed to set permissions for pipelines %v on %s: %v", pipelineIds, sink.Describe(obj.Name), err)
+		}
+		audit(obj.Source, permissionsEntry)
+	}
+
+	if bestMatch == nil {
+		log.Printf("No matching pipeline found for matchable part %s", matchPart)
+		return nil, nil // No matching pipeline found, but this isn't necessarily an error
+	}
+
+	batch.add(route, sink, obj, *bestMatch)
+	return bestMatch, nil
 }
 
-// Fetches secure file ID to be used in setting permissions
-func getSecureFileId(ctx context.Context, pat, org, project, fileName string) (string, error) {
-    apiURL := fmt.Sprintf(
-        "https://dev.azure.com/%s/%s/_apis/distributedtask/securefiles?api-version=7.0-preview",
-        org, project,
-    )
-
-    req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-    if err != nil {
-        return "", fmt.Errorf("failed to create request: %v", err)
-    }
-
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-
-    client := &http.Client{Timeout: 30 * time.Second}
-    resp, err := client.Do(req)
-    if err != nil {
-        return "", err
-    }
-    defer resp.Body.Close()
-
-    var result struct {
-        Value []struct {
-            Id   string `json:"id"`
-            Name string `json:"name"`
-        } `json:"value"`
-    }
-
-    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-        return "", err
-    }
-
-    for _, file := range result.Value {
-        if file.Name == fileName {
-            return file.Id, nil
-        }
-    }
-
-    return "", fmt.Errorf("secure file not found: %s", fileName)
+// findBestPipeline returns the pipeline whose name shares the most letters with matchPart, or nil if none match
+func findBestPipeline(ctx context.Context, pat, org, project, matchPart string) (*PipelineMatch, error) {
+	pipelines, err := listPipelines(ctx, pat, org, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the pipeline with the most matching letters for the part after the last dot
+	bestMatch := PipelineMatch{Score: -1} // Initialize with a score lower than possible
+
+	for _, pipeline := range pipelines {
+		score := calculateMatchScore(strings.ToLower(matchPart), strings.ToLower(pipeline.Name))
+		if score > bestMatch.Score {
+			bestMatch = PipelineMatch{Id: pipeline.Id, Name: pipeline.Name, Score: score}
+		}
+	}
+
+	if bestMatch.Score <= 0 {
+		return nil, nil
+	}
+	return &bestMatch, nil
 }
 
-// setSecureFilePermissions sets permissions for a pipeline on a secure file
-func setSecureFilePermissions(ctx context.Context, pat, org, project, secureFileName string, pipelineId int) error {
-    fileId, err := getSecureFileId(ctx, pat, org, project, secureFileName)
-    if err != nil {
-        return fmt.Errorf("failed to get secure file ID: %v", err)
-    }
-
-    apiURL := fmt.Sprintf(
-        "https://dev.azure.com/%s/%s/_apis/pipelines/pipelinePermissions/securefile/%s?api-version=7.0-preview",
-        org, project, fileId,
-    )
-
-    jsonPayload := map[string]interface{}{
-        "allPipelines": map[string]interface{}{
-            "authorized": false,
-            "authorizedBy": nil,
-            "authorizedOn": nil,
-        },
-        "pipelines": []map[string]interface{}{
-            {
-                "id": pipelineId,
-                "authorized": true,
-            },
-        },
-    }
-
-    payloadBytes, err := json.Marshal(jsonPayload)
-    if err != nil {
-        return fmt.Errorf("failed to marshal JSON: %v", err)
-    }
-
-    req, err := http.NewRequestWithContext(ctx, "PATCH", apiURL, bytes.NewReader(payloadBytes))
-    if err != nil {
-        return fmt.Errorf("failed to create request: %v", err)
-    }
-
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-    req.Header.Set("Content-Type", "application/json")
-
-    client := &http.Client{Timeout: 30 * time.Second}
-    resp, err := client.Do(req)
-    if err != nil {
-        return fmt.Errorf("request failed: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        bodyBytes, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-    }
-
-    return nil
+// Pipeline is an Azure DevOps pipeline definition
+type Pipeline struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
 }
 
-// triggerCIByMatchablePart searches for a pipeline with the most matching letters in the string after the last dot of the filename
-func triggerCIByMatchablePart(ctx context.Context, matchPart, project, filename string) error {
-    pat := os.Getenv("AZURE_DEVOPS_PAT")
-    org := os.Getenv("AZURE_DEVOPS_ORG")
-
-    if pat == "" || org == "" || project == "" {
-        return fmt.Errorf("missing environment variables: AZURE_DEVOPS_PAT, AZURE_DEVOPS_ORG, or project")
-    }
-
-    // Fetch all pipelines to find a match
-    pipelinesURL := fmt.Sprintf("https://dev.azure.com/%s/%s/_apis/pipelines?api-version=7.1-preview.1", org, project)
-
-    req, err := http.NewRequestWithContext(ctx, "GET", pipelinesURL, nil)
-    if err != nil {
-        return fmt.Errorf("failed to create request for pipelines: %v", err)
-    }
-    req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-
-    client := &http.Client{
-        Timeout: 30 * time.Second,
-    }
-    resp, err := client.Do(req)
-    if err != nil {
-        return fmt.Errorf("failed to fetch pipelines: %v", err)
-    }
-    defer resp.Body.Close()
-
-    if resp.StatusCode != http.StatusOK {
-        bodyBytes, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("failed to get pipelines: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
-    }
-
-    var pipelineList struct {
-        Value []struct {
-            Id   int    `json:"id"`
-            Name string `json:"name"`
-        } `json:"value"`
-    }
-    if err := json.NewDecoder(resp.Body).Decode(&pipelineList); err != nil {
-        return fmt.Errorf("failed to decode pipeline list: %v", err)
-    }
-
-    // Find the pipeline with the most matching letters for the part after the last dot
-    var bestMatch struct {
-        Pipeline struct {
-            Id   int
-            Name string
-        }
-        Score int
-    }
-    bestMatch.Score = -1 // Initialize with a score lower than possible
-
-    for _, pipeline := range pipelineList.Value {
-        score := calculateMatchScore(strings.ToLower(matchPart), strings.ToLower(pipeline.Name))
-        if score > bestMatch.Score {
-            bestMatch.Score = score
-            bestMatch.Pipeline.Id = pipeline.Id
-            bestMatch.Pipeline.Name = pipeline.Name
-        }
-    }
-
-    if bestMatch.Score > 0 {
-        // Set permissions for the pipeline on the secure file before triggering
-        if err := setSecureFilePermissions(ctx, pat, org, project, filename, bestMatch.Pipeline.Id); err != nil {
-            return fmt.Errorf("failed to set permissions for pipeline %d on file %s: %v", bestMatch.Pipeline.Id, filename, err)
-        }
-
-        // Trigger the best matching pipeline
-        triggerURL := fmt.Sprintf("https://dev.azure.com/%s/%s/_apis/pipelines/%d/runs?api-version=7.1-preview.1", org, project, bestMatch.Pipeline.Id)
-
-        jsonPayload := map[string]interface{}{
-            "resources": map[string]interface{}{
-                "repositories": map[string]interface{}{
-                    
-                },
-            },
-        }
-
-        payloadBytes, err := json.Marshal(jsonPayload)
-        if err != nil {
-            return fmt.Errorf("failed to marshal JSON payload for CI trigger: %v", err)
-        }
-
-        req, err := http.NewRequestWithContext(ctx, "POST", triggerURL, bytes.NewReader(payloadBytes))
-        if err != nil {
-            return fmt.Errorf("failed to create CI trigger request: %v", err)
-        }
-        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
-        req.Header.Set("Content-Type", "application/json")
-
-        resp, err := client.Do(req)
-        if err != nil {
-            return fmt.Errorf("failed to trigger CI/CD: %v", err)
-        }
-        defer resp.Body.Close()
-
-        bodyBytes, _ := io.ReadAll(resp.Body)
-        if resp.StatusCode != http.StatusCreated {
-            if resp.StatusCode == http.StatusOK {
-                // Consider logging this for debugging or monitoring
-                // log.Printf("CI/CD trigger responded with status code 200")
-            } else {
-                return fmt.Errorf("CI/CD trigger failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
-            }
-        }
-
-        log.Printf("Successfully triggered pipeline %s", bestMatch.Pipeline.Name)
-        return nil
-    } else {
-        log.Printf("No matching pipeline found for matchable part %s", matchPart)
-    }
-    return nil // No matching pipeline found, but this isn't necessarily an error
+// pipelineCache holds pipeline listings per org/project for AZURE_CACHE_TTL (default 1m)
+var pipelineCache = core.NewTTLCache[[]Pipeline]("pipelines", "AZURE_CACHE_TTL", time.Minute)
+
+// listPipelines returns all pipelines in a project, cached for AZURE_CACHE_TTL
+func listPipelines(ctx context.Context, pat, org, project string) ([]Pipeline, error) {
+	return pipelineCache.Get(org+"/"+project, func() ([]Pipeline, error) {
+		return fetchPipelines(ctx, pat, org, project)
+	})
+}
+
+// fetchPipelines fetches all pipelines in a project
+func fetchPipelines(ctx context.Context, pat, org, project string) ([]Pipeline, error) {
+	pipelinesURL := fmt.Sprintf("%s/%s/%s/_apis/pipelines?api-version=7.1-preview.1", core.AzureBaseURL(), org, project)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pipelinesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for pipelines: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pipelines: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get pipelines: status code %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var pipelineList struct {
+		Value []Pipeline `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pipelineList); err != nil {
+		return nil, fmt.Errorf("failed to decode pipeline list: %v", err)
+	}
+
+	return pipelineList.Value, nil
+}
+
+// TriggerPipeline queues a run of a pipeline given by numeric id or exact name
+func TriggerPipeline(ctx context.Context, project, pipeline string) (Pipeline, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+
+	if pat == "" || org == "" || project == "" {
+		return Pipeline{}, fmt.Errorf("missing environment variables: AZURE_DEVOPS_PAT, AZURE_DEVOPS_ORG, or project")
+	}
+
+	pipelines, err := listPipelines(ctx, pat, org, project)
+	if err != nil {
+		return Pipeline{}, err
+	}
+
+	for _, candidate := range pipelines {
+		if strconv.Itoa(candidate.Id) == pipeline || candidate.Name == pipeline {
+			run, err := triggerPipeline(ctx, pat, org, project, candidate.Id, nil)
+			if err != nil {
+				return candidate, err
+			}
+			log.Printf("Successfully triggered pipeline %s, run %d %s", candidate.Name, run.Id, run.URL)
+			return candidate, nil
+		}
+	}
+
+	return Pipeline{}, fmt.Errorf("pipeline %s not found in project %s", pipeline, project)
+}
+
+// PipelineRun is a run queued by triggerPipeline
+type PipelineRun struct {
+	Id         int    `json:"id"`
+	PipelineId int    `json:"pipeline_id"`
+	Pipeline   string `json:"pipeline,omitempty"`
+	State      string `json:"state,omitempty"`
+	Result     string `json:"result,omitempty"`
+	URL        string `json:"url,omitempty"` // link to the run in the Azure DevOps web UI
+}
+
+// triggerPipeline queues a run of the given pipeline, with the parameters of its route when given
+func triggerPipeline(ctx context.Context, pat, org, project string, pipelineId int, params *RunConfig) (*PipelineRun, error) {
+	triggerURL := fmt.Sprintf("%s/%s/%s/_apis/pipelines/%d/runs?api-version=7.1-preview.1", core.AzureBaseURL(), org, project, pipelineId)
+
+	repositories := map[string]interface{}{}
+	jsonPayload := map[string]interface{}{
+		"resources": map[string]interface{}{
+			"repositories": repositories,
+		},
+	}
+	if params != nil {
+		if params.RefName != "" {
+			repositories["self"] = map[string]interface{}{"refName": params.RefName}
+		}
+		if len(params.TemplateParameters) > 0 {
+			jsonPayload["templateParameters"] = params.TemplateParameters
+		}
+		if len(params.Variables) > 0 {
+			variables := map[string]interface{}{}
+			for name, value := range params.Variables {
+				variables[name] = map[string]interface{}{"value": value}
+			}
+			jsonPayload["variables"] = variables
+		}
+		if len(params.StagesToSkip) > 0 {
+			jsonPayload["stagesToSkip"] = params.StagesToSkip
+		}
+	}
+
+	payloadBytes, err := json.Marshal(jsonPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON payload for CI trigger: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", triggerURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CI trigger request: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to trigger CI/CD: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		if resp.StatusCode == http.StatusOK {
+			// Consider logging this for debugging or monitoring
+			// log.Printf("CI/CD trigger responded with status code 200")
+		} else {
+			return nil, fmt.Errorf("CI/CD trigger failed with status code %d: %s", resp.StatusCode, string(bodyBytes))
+		}
+	}
+
+	var runResponse struct {
+		Id     int    `json:"id"`
+		State  string `json:"state"`
+		Result string `json:"result"`
+		Links  struct {
+			Web struct {
+				Href string `json:"href"`
+			} `json:"web"`
+		} `json:"_links"`
+	}
+	if err := json.Unmarshal(bodyBytes, &runResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode run response for pipeline %d: %v", pipelineId, err)
+	}
+	if runResponse.Id == 0 {
+		return nil, fmt.Errorf("run response for pipeline %d has no run id", pipelineId)
+	}
+
+	return &PipelineRun{
+		Id:         runResponse.Id,
+		PipelineId: pipelineId,
+		State:      runResponse.State,
+		Result:     runResponse.Result,
+		URL:        runResponse.Links.Web.Href,
+	}, nil
 }
 
-func ProcessWebhookEvent(webhookData map[string]interface{}) error {
-    ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-
-    repo, ok := webhookData["repository"].(map[string]interface{})
-    if !ok {
-        return fmt.Errorf("no repository data found")
-    }
-
-    fullName, ok := repo["full_name"].(string)
-    if !ok {
-        return fmt.Errorf("could not extract repository full name")
-    }
-
-    files, ok := webhookData["commits"].([]interface{})
-    if !ok || len(files) == 0 {
-        return fmt.Errorf("no files found in webhook")
-    }
-
-    for _, commitInterface := range files {
-        commit, ok := commitInterface.(map[string]interface{})
-        if !ok {
-            log.Printf("Error processing commit data: %v", commitInterface)
-            continue
-        }
-
-        modifiedFiles, ok := commit["modified"].([]interface{})
-        if !ok {
-            log.Printf("Error getting modified files from commit: %v", commit)
-            continue
-        }
-
-        for _, fileInterface := range modifiedFiles {
-            filename, ok := fileInterface.(string)
-            if !ok {
-                log.Printf("Error converting modified file to string: %v", fileInterface)
-                continue
-            }
-
-            fileContent, err := core.FetchFileFromGitHub(fullName, filename)
-            if err != nil {
-                log.Printf("GitHub file fetch error for %s: %v", filename, err)
-                continue
-            }
-
-            if err := os.WriteFile("security.txt", fileContent, 0644); err != nil {
-                log.Printf("Failed to write temporary security.txt: %v", err)
-                continue
-            }
-
-            project := getProjectForFile(filepath.Base(filename))
-            if err := os.Setenv("AZURE_DEVOPS_PROJECT", project); err != nil {
-                log.Printf("Failed to set environment variable for project: %v", err)
-                continue
-            }
-
-            err = core.UpdateAzureDevOpsFile(ctx, filepath.Base(filename))
-            if err != nil {
-                if !isSuccessError(err) {
-                    log.Printf("Azure DevOps update error for %s: %v", filename, err)
-                    continue
-                }
-                log.Printf("File %s successfully updated in Azure DevOps", filename)
-            } else {
-                log.Printf("Successfully processed file: %s in project %s", filename, project)
-            }
-
-            // Trigger CI/CD based on the part of filename after last dot
-            matchPart := getMatchablePartFromFilename(filepath.Base(filename))
-            if err := triggerCIByMatchablePart(ctx, matchPart, project, filepath.Base(filename)); err != nil {
-                log.Printf("Failed to trigger CI/CD for matchable part %s: %v", matchPart, err)
-            }
-        }
-
-        // Delete the temporary security.txt file after processing all files in the commit
-        if err := os.Remove("security.txt"); err != nil {
-            log.Printf("Failed to delete security.txt: %v", err)
-        } 
-    }
-
-    return nil
+// getPipelineRun fetches the current state of a run
+func getPipelineRun(ctx context.Context, pat, org, project string, pipelineId, runId int) (*PipelineRun, error) {
+	runURL := fmt.Sprintf("%s/%s/%s/_apis/pipelines/%d/runs/%d?api-version=7.1-preview.1", core.AzureBaseURL(), org, project, pipelineId, runId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", runURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for run: %v", err)
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+pat)))
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch run: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get run %d: status code %d, body: %s", runId, resp.StatusCode, string(bodyBytes))
+	}
+
+	var run struct {
+		State  string `json:"state"`
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode run: %v", err)
+	}
+
+	return &PipelineRun{Id: runId, PipelineId: pipelineId, State: run.State, Result: run.Result}, nil
 }
 
+// SyncFiles fetches files from GitHub at ref and syncs them to their sinks, or plans them in dry-run mode.
+// Pipelines are triggered once all files are uploaded, once per pipeline. Files that fail are
+// returned by path and left out of the plan.
+func SyncFiles(ctx context.Context, repoFullName, ref string, paths []string, opts ProcessOptions) (*Plan, map[string]error) {
+	if core.EnvBool("DRY_RUN", false) {
+		opts.DryRun = true
+	}
+
+	plan := &Plan{Repository: repoFullName, DryRun: opts.DryRun}
+	failed := map[string]error{}
+	opts.triggers = newTriggerBatch(opts)
+	for _, path := range paths {
+		filePlan, err := syncFile(ctx, sinks.Source{Repository: repoFullName, Ref: ref, Path: path}, opts)
+		if err != nil {
+			failed[path] = err
+			continue
+		}
+		plan.Files = append(plan.Files, filePlan)
+	}
+
+	results := opts.triggers.flush(ctx)
+	for i := range plan.Files {
+		if result, ok := results[plan.Files[i].Path]; ok {
+			applyTriggerResult(&plan.Files[i], result)
+		}
+	}
+	return plan, failed
+}
+
+// syncFile fetches one file from GitHub at source.Ref and syncs it with syncContent
+func syncFile(ctx context.Context, source sinks.Source, opts ProcessOptions) (FilePlan, error) {
+	fileContent, err := core.FetchFileFromGitHubAtRef(ctx, source.Repository, source.Path, source.Ref)
+	if err != nil {
+		return FilePlan{}, fmt.Errorf("GitHub file fetch error for %s: %v", source.Path, err)
+	}
+	return syncContent(ctx, source, fileContent, opts)
+}
+
+// syncContent writes a file to the sink chosen by its route and triggers the matching pipeline,
+// recording the attempt in the store
+func syncContent(ctx context.Context, source sinks.Source, fileContent []byte, opts ProcessOptions) (FilePlan, error) {
+	filePlan, err := writeContent(ctx, source, fileContent, opts)
+	recordSyncAttempt(source, fileContent, filePlan, err)
+	return filePlan, err
+}
+
+// writeContent validates a file, writes it to its sink and triggers or queues its pipeline
+func writeContent(ctx context.Context, source sinks.Source, fileContent []byte, opts ProcessOptions) (FilePlan, error) {
+	filename := source.Path
+	route, err := routeForFile(filepath.Base(filename))
+	if err != nil {
+		return FilePlan{}, fmt.Errorf("failed to resolve route for %s: %v", filename, err)
+	}
+	sink, err := newSink(ctx, route)
+	if err != nil {
+		return FilePlan{Path: filename, Project: route.Project, Error: err.Error()}, fmt.Errorf("failed to create %s sink for %s: %v", route.SinkName(), filename, err)
+	}
+
+	// Bound the files processed at once against the same organization
+	release, err := acquireOrgSlot(ctx, limiterKey(route))
+	if err != nil {
+		return FilePlan{Path: filename, Project: route.Project, Error: err.Error()}, err
+	}
+	defer release()
+
+	obj := sinks.Object{Name: filepath.Base(filename), Content: fileContent, Source: source}
+	matchPart := getMatchablePartFromFilename(obj.Name)
+
+	// Refuse to write files that are not valid dotenv, such as unresolved merges or YAML
+	var issues []ValidationIssue
+	if core.EnvBool("VALIDATE_ENV_FILES", true) {
+		issues = ValidateEnvFile(fileContent)
+		if !hasValidationErrors(issues) {
+			schemaIssues, err := checkSchema(ctx, route, source, fileContent)
+			if err != nil {
+				return FilePlan{Path: filename, Project: route.Project, Error: err.Error()}, fmt.Errorf("schema check failed for %s: %v", filename, err)
+			}
+			issues = append(issues, schemaIssues...)
+		}
+		if hasValidationErrors(issues) {
+			reportCommitStatus(ctx, source, "failure", "Invalid env file: "+summarizeIssues(issues), "")
+			filePlan := newFilePlan(route, sink, obj)
+			filePlan.Issues = issues
+			filePlan.Error = "validation failed: " + summarizeIssues(issues)
+			return filePlan, fmt.Errorf("%s failed validation: %s", filename, summarizeIssues(issues))
+		}
+	}
+
+	// Compare keys with the previous version of the file, without exposing values
+	var diff *KeyDiff
+	if core.EnvBool("DIFF_ENABLED", true) {
+		if diff, err = diffWithPrevious(ctx, source, fileContent); err != nil {
+			log.Printf("Failed to diff %s with its previous version: %v", filename, err)
+		} else if diff != nil {
+			log.Printf("Key changes for %s since %.7s: %s", filename, diff.From, diff)
+		}
+	}
+
+	if opts.DryRun || route.DryRun {
+		filePlan := planFile(ctx, route, sink, obj, matchPart, !opts.preview)
+		filePlan.Issues = issues
+		filePlan.Diff = diff
+		logFilePlan(filePlan)
+		if filePlan.Error != "" {
+			reportCommitStatus(ctx, source, "error", "Dry run failed: "+filePlan.Error, "")
+		} else {
+			reportCommitStatus(ctx, source, "success", "Validated; dry run, would "+filePlan.Action+" "+filePlan.Target, "")
+		}
+		return filePlan, nil
+	}
+	filePlan := newFilePlan(route, sink, obj)
+	filePlan.Issues = issues
+	filePlan.Diff = diff
+
+	// Writes and permission changes to one target never overlap
+	unlock := targetLocks.Lock(filePlan.Key)
+	defer unlock()
+
+	// Deliveries may be processed out of order, never replace a newer commit's content with an older one
+	if stale, applied := isStaleSource(ctx, filePlan.Target, source); stale {
+		log.Printf("Skipping %s at %.7s: %s already holds the newer commit %.7s", filename, source.SHA, filePlan.Target, applied)
+		filePlan.Action = "superseded"
+		reportCommitStatus(ctx, source, "success", fmt.Sprintf("Superseded by %.7s in %s; upload skipped", applied, filePlan.Target), "")
+		return filePlan, nil
+	}
+
+	// Read the hash of the current content, to skip unchanged files and for the audit log
+	hashAfter := core.TargetHash(fileContent)
+	hashBefore, exists, err := sink.Hash(ctx, obj.Name)
+	if err == nil && exists && hashBefore == "" {
+		// Fall back to the hash we stored for sinks that could not record one
+		if stored, ok, _ := store.Default().GetContentHash(filePlan.Target); ok {
+			hashBefore = stored.Hash
+		}
+	}
+
+	// Skip the upload and trigger when the sink already holds this content, unless forced
+	if !opts.Force && !core.EnvBool("FORCE_SYNC", false) {
+		if err != nil {
+			log.Printf("Failed to read the recorded hash of %s, uploading anyway: %v", filePlan.Target, err)
+		} else if exists && hashBefore == hashAfter {
+			log.Printf("Skipping %s: content unchanged in %s", filename, filePlan.Target)
+			core.IncCounter("sync_skipped_unchanged", "sink", sink.Kind())
+			filePlan.Action = "unchanged"
+			recordAppliedCommit(filePlan.Target, source)
+			reportCommitStatus(ctx, source, "success", "Unchanged in "+filePlan.Target+"; upload skipped", "")
+			return filePlan, nil
+		}
+	}
+
+	reportCommitStatus(ctx, source, "pending", "Validated; uploading to "+filePlan.Target, "")
+	entryType := "sync"
+	if opts.rollback {
+		entryType = "rollback"
+	}
+	putErr := sink.Put(ctx, obj)
+	syncEntry := auditTarget(ctx, store.AuditEntry{Type: entryType, HashBefore: hashBefore, HashAfter: hashAfter, Keys: auditKeys(diff)}, route, sink, obj.Name)
+	if putErr != nil {
+		filePlan.Error = putErr.Error()
+		syncEntry.Error = putErr.Error()
+		audit(source, syncEntry)
+		reportCommitStatus(ctx, source, "error", "Upload to "+filePlan.Target+" failed", "")
+		return filePlan, fmt.Errorf("update error for %s: %v", sink.Describe(obj.Name), putErr)
+	}
+	audit(source, syncEntry)
+	recordAppliedCommit(filePlan.Target, source)
+	log.Printf("Successfully processed file: %s -> %s", filename, sink.Describe(obj.Name))
+	notifyFileSynced(ctx, source, filePlan)
+
+	if opts.SkipTrigger {
+		reportCommitStatus(ctx, source, "success", "Uploaded to "+filePlan.Target, "")
+		return filePlan, nil
+	}
+
+	// Trigger CI/CD based on the part of filename after last dot. Callers syncing several files
+	// pass a batch so that each pipeline runs once, otherwise the pipeline is triggered right away.
+	batch := opts.triggers
+	if batch == nil {
+		batch = newTriggerBatch(opts)
+	}
+	pipeline, err := triggerCIByMatchablePart(ctx, batch, route, sink, obj, matchPart)
+	if err != nil {
+		log.Printf("Failed to trigger CI/CD for matchable part %s: %v", matchPart, err)
+		filePlan.Error = err.Error()
+		reportCommitStatus(ctx, source, "failure", "Uploaded to "+filePlan.Target+" but the pipeline trigger failed", "")
+		return filePlan, nil
+	}
+	if pipeline == nil {
+		reportCommitStatus(ctx, source, "success", "Uploaded to "+filePlan.Target+"; no pipeline triggered", "")
+		return filePlan, nil
+	}
+	filePlan.Pipeline = pipeline
+
+	if opts.triggers == nil {
+		applyTriggerResult(&filePlan, batch.flush(ctx)[source.Path])
+	} else {
+		reportCommitStatus(ctx, source, "pending", fmt.Sprintf("Uploaded to %s; waiting to trigger %s", filePlan.Target, pipeline.Name), "")
+	}
+	return filePlan, nil
+}
+
+// deleteFile deletes the target of a file removed from the repository. Pipelines are not triggered.
+func deleteFile(ctx context.Context, source sinks.Source, opts ProcessOptions) (FilePlan, error) {
+	filename := source.Path
+	route, err := routeForFile(filepath.Base(filename))
+	if err != nil {
+		return FilePlan{}, fmt.Errorf("failed to resolve route for %s: %v", filename, err)
+	}
+	sink, err := newSink(ctx, route)
+	if err != nil {
+		return FilePlan{Path: filename, Project: route.Project, Error: err.Error()}, fmt.Errorf("failed to create %s sink for %s: %v", route.SinkName(), filename, err)
+	}
+
+	name := filepath.Base(filename)
+	filePlan := newFilePlan(route, sink, sinks.Object{Name: name, Source: source})
+	filePlan.Action = "delete"
+	if opts.DryRun || route.DryRun {
+		filePlan.DryRun = true
+		logFilePlan(filePlan)
+		return filePlan, nil
+	}
+
+	unlock := targetLocks.Lock(filePlan.Key)
+	defer unlock()
+
+	// A removal older than the content the target holds must not delete it
+	if stale, applied := isStaleSource(ctx, filePlan.Target, source); stale {
+		log.Printf("Not deleting %s for %s at %.7s: it already holds the newer commit %.7s", filePlan.Target, filename, source.SHA, applied)
+		filePlan.Action = "superseded"
+		return filePlan, nil
+	}
+
+	hashBefore, _, _ := sink.Hash(ctx, name)
+	deleteEntry := auditTarget(ctx, store.AuditEntry{Type: "delete", HashBefore: hashBefore}, route, sink, name)
+	if err := sink.Delete(ctx, name); err != nil {
+		filePlan.Error = err.Error()
+		deleteEntry.Error = err.Error()
+		audit(source, deleteEntry)
+		return filePlan, fmt.Errorf("delete error for %s: %v", filePlan.Target, err)
+	}
+	audit(source, deleteEntry)
+	recordAppliedCommit(filePlan.Target, source)
+	log.Printf("Deleted %s, %s was removed from %s", filePlan.Target, filename, source.Repository)
+	return filePlan, nil
+}
+
+// applyTriggerResult records the pipeline run of a file in its plan
+func applyTriggerResult(filePlan *FilePlan, result triggerResult) {
+	filePlan.Run = result.Run
+	filePlan.Job = result.Job
+	filePlan.TriggerScheduled = result.Scheduled
+	if result.Err != nil {
+		filePlan.Error = result.Err.Error()
+	}
+}
+
+// recordSyncAttempt stores a sync attempt and, after a successful write, the target's content hash and version
+func recordSyncAttempt(source sinks.Source, fileContent []byte, filePlan FilePlan, err error) {
+	db := store.Default()
+	if db == nil || filePlan.Target == "" {
+		return
+	}
+
+	attempt := store.SyncAttempt{
+		Time:       time.Now().UTC(),
+		Repository: source.Repository,
+		SHA:        source.SHA,
+		Path:       source.Path,
+		Sink:       filePlan.Sink,
+		Target:     filePlan.Target,
+		Action:     filePlan.Action,
+		Hash:       core.TargetHash(fileContent),
+		Error:      filePlan.Error,
+	}
+	if err != nil && attempt.Error == "" {
+		attempt.Error = err.Error()
+	}
+	switch {
+	case filePlan.DryRun:
+		attempt.Action = "dry_run"
+	case attempt.Action == "":
+		attempt.Action = "upload"
+	}
+	if storeErr := db.AddSyncAttempt(attempt); storeErr != nil {
+		log.Printf("Failed to record sync attempt for %s: %v", source.Path, storeErr)
+	}
+
+	if err == nil && attempt.Action == "upload" {
+		hash := store.ContentHash{Target: filePlan.Target, Hash: attempt.Hash, Repository: source.Repository, SHA: source.SHA, UpdatedAt: attempt.Time}
+		if storeErr := db.SetContentHash(hash); storeErr != nil {
+			log.Printf("Failed to record content hash for %s: %v", filePlan.Target, storeErr)
+		}
+		recordVersion(source, filePlan, fileContent)
+	}
+}
+
+// reportCommitStatus sets the status of a file on its source commit under the context env-updater/<path>.
+// It is a no-op without a commit SHA or when GITHUB_COMMIT_STATUS=false.
+func reportCommitStatus(ctx context.Context, source sinks.Source, state, description, targetURL string) {
+	if source.SHA == "" || !core.EnvBool("GITHUB_COMMIT_STATUS", true) {
+		return
+	}
+	if err := core.SetCommitStatus(ctx, source.Repository, source.SHA, "env-updater/"+source.Path, state, description, targetURL); err != nil {
+		log.Printf("Failed to report commit status for %s: %v", source.Path, err)
+	}
+}
+
+// ProcessOptions controls how a webhook event is processed
+type ProcessOptions struct {
+	DryRun      bool // resolve and report changes without writing to any sink or triggering pipelines
+	SkipTrigger bool // upload files without triggering their pipelines
+	Force       bool // upload and trigger even when the sink already holds the same content
+	NoDebounce  bool // trigger pipelines right away despite TRIGGER_DEBOUNCE, e.g. in the CLI, which exits before a deferred run
+
+	triggers *triggerBatch // collects pipeline runs until all files are uploaded, see triggerBatch
+	rollback bool          // the content is a previous version restored by Rollback
+	preview  bool          // plan untrusted content without reading the target, see planFile
+}
+
+// ProcessWebhookEvent syncs the files added or modified by a push to Azure DevOps and returns the plan that was applied.
+// In dry-run mode (opts.DryRun, DRY_RUN=true or a route with dry_run set) the plan is only reported.
+func ProcessWebhookEvent(webhookData map[string]interface{}, opts ProcessOptions) (*Plan, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if core.EnvBool("DRY_RUN", false) {
+		opts.DryRun = true
+	}
+
+	repo, ok := webhookData["repository"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no repository data found")
+	}
+
+	fullName, ok := repo["full_name"].(string)
+	if !ok {
+		return nil, fmt.Errorf("could not extract repository full name")
+	}
+
+	files, ok := webhookData["commits"].([]interface{})
+	if !ok || len(files) == 0 {
+		return nil, fmt.Errorf("no files found in webhook")
+	}
+
+	plan := &Plan{Repository: fullName, DryRun: opts.DryRun}
+
+	// Only the configured branch is synced, pushes to other branches are ignored
+	if pushRef, _ := webhookData["ref"].(string); !isDefaultBranch(pushRef) {
+		log.Printf("Ignoring push to %s in %s, only %s is synced", pushRef, fullName, core.DefaultRef())
+		return plan, nil
+	}
+
+	// Collapse the commits of the push to the latest version of each changed file,
+	// diffed against the push's "before" SHA
+	before, _ := webhookData["before"].(string)
+	sources, removed := coalescePush(fullName, before, pushActor(webhookData), files)
+
+	// Targets of removed files are only deleted when asked to, they may still be in use
+	var removals []sinks.Source
+	if core.EnvBool("DELETE_REMOVED_FILES", false) {
+		removals = removed
+	}
+
+	// Upload every file before triggering, so that each affected pipeline runs once.
+	// Files are independent after coalescing and are processed in parallel.
+	opts.triggers = newTriggerBatch(opts)
+	filePlans := make([]FilePlan, len(sources))
+	forEachConcurrently(len(sources), core.EnvInt("SYNC_CONCURRENCY", 4), func(i int) {
+		filePlan, err := syncFile(ctx, sources[i], opts)
+		if err != nil {
+			log.Printf("Failed to sync %s: %v", sources[i].Path, err)
+		}
+		filePlans[i] = filePlan // Failures such as validation errors are reported too
+	})
+	for _, source := range removals {
+		filePlan, err := deleteFile(ctx, source, opts)
+		if err != nil {
+			log.Printf("Failed to delete the target of %s: %v", source.Path, err)
+		}
+		filePlans = append(filePlans, filePlan)
+	}
+	for _, filePlan := range filePlans {
+		if filePlan.Path != "" {
+			plan.Files = append(plan.Files, filePlan)
+		}
+	}
+
+	results := opts.triggers.flush(ctx)
+	for i := range plan.Files {
+		if result, ok := results[plan.Files[i].Path]; ok {
+			applyTriggerResult(&plan.Files[i], result)
+		}
+	}
+
+	return plan, nil
+}
+
+// coalescePush collapses the commits of a push to one source per file added or modified,
+// credited to the last commit that changed it, and one per file the push removed. A file
+// removed and added back within the push is synced, not removed.
+func coalescePush(fullName, before, actor string, commits []interface{}) ([]sinks.Source, []sinks.Source) {
+	var sources []sinks.Source
+	latest := map[string]int{}           // path -> index in sources
+	removed := map[string]sinks.Source{} // path -> commit removing it
+
+	for _, commitInterface := range commits {
+		commit, ok := commitInterface.(map[string]interface{})
+		if !ok {
+			log.Printf("Error processing commit data: %v", commitInterface)
+			continue
+		}
+		commitSHA, _ := commit["id"].(string)
+
+		addedFiles, _ := commit["added"].([]interface{})
+		modifiedFiles, _ := commit["modified"].([]interface{})
+		for _, fileInterface := range append(addedFiles, modifiedFiles...) {
+			filename, ok := fileInterface.(string)
+			if !ok {
+				log.Printf("Error converting changed file to string: %v", fileInterface)
+				continue
+			}
+			if isSchemaFile(filename) {
+				continue // Schemas are read alongside their env file, not synced
+			}
+			delete(removed, filename)
+
+			// Content is read at the commit it is credited to
+			if i, ok := latest[filename]; ok {
+				sources[i].SHA = commitSHA
+				sources[i].Ref = commitSHA
+				continue
+			}
+			latest[filename] = len(sources)
+			sources = append(sources, sinks.Source{Repository: fullName, Path: filename, Ref: commitSHA, SHA: commitSHA, Parent: before, Actor: actor})
+		}
+
+		// A file removed by a later commit of the push is no longer synced
+		removedFiles, _ := commit["removed"].([]interface{})
+		for _, fileInterface := range removedFiles {
+			filename, ok := fileInterface.(string)
+			if !ok || isSchemaFile(filename) {
+				continue
+			}
+			if i, ok := latest[filename]; ok {
+				sources = append(sources[:i], sources[i+1:]...)
+				delete(latest, filename)
+				for path, j := range latest {
+					if j > i {
+						latest[path] = j - 1
+					}
+				}
+			}
+			removed[filename] = sinks.Source{Repository: fullName, Path: filename, SHA: commitSHA, Parent: before, Actor: actor}
+		}
+	}
+
+	removals := make([]sinks.Source, 0, len(removed))
+	for _, source := range removed {
+		removals = append(removals, source)
+	}
+	sort.Slice(removals, func(i, j int) bool { return removals[i].Path < removals[j].Path })
+	return sources, removals
+}
+
+// pushActor returns who pushed, from the pusher or, failing that, the sender of the event
+func pushActor(webhookData map[string]interface{}) string {
+	if pusher, ok := webhookData["pusher"].(map[string]interface{}); ok {
+		if name, ok := pusher["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	if sender, ok := webhookData["sender"].(map[string]interface{}); ok {
+		if login, ok := sender["login"].(string); ok {
+			return login
+		}
+	}
+	return ""
+}
+
+// isDefaultBranch reports whether a push ref such as refs/heads/main is the branch given by core.DefaultRef
+func isDefaultBranch(pushRef string) bool {
+	return strings.TrimPrefix(pushRef, "refs/heads/") == strings.TrimPrefix(core.DefaultRef(), "refs/heads/")
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"env-updater/sinks"
+)
+
+// pushCommit builds a commit as sent in a push webhook payload
+func pushCommit(id string, added, modified, removed []string) interface{} {
+	list := func(paths []string) []interface{} {
+		items := make([]interface{}, len(paths))
+		for i, path := range paths {
+			items[i] = path
+		}
+		return items
+	}
+	return map[string]interface{}{"id": id, "added": list(added), "modified": list(modified), "removed": list(removed)}
+}
+
+// sourceSummary lists sources as path@sha pairs
+func sourceSummary(sources []sinks.Source) string {
+	var summary []string
+	for _, source := range sources {
+		summary = append(summary, source.Path+"@"+source.SHA)
+	}
+	return strings.Join(summary, " ")
+}
+
+func TestCoalescePush(t *testing.T) {
+	tests := []struct {
+		name        string
+		commits     []interface{}
+		wantSources string
+		wantRemoved string
+	}{
+		{
+			name: "a file changed by several commits is synced once at the last one",
+			commits: []interface{}{
+				pushCommit("c1", nil, []string{"a.env", "b.env"}, nil),
+				pushCommit("c2", nil, []string{"a.env"}, nil),
+			},
+			wantSources: "a.env@c2 b.env@c1",
+		},
+		{
+			name: "added files are synced",
+			commits: []interface{}{
+				pushCommit("c1", []string{"new.env"}, nil, nil),
+				pushCommit("c2", nil, []string{"new.env"}, nil),
+			},
+			wantSources: "new.env@c2",
+		},
+		{
+			name: "a file removed then added back is synced and not removed",
+			commits: []interface{}{
+				pushCommit("c1", nil, []string{"a.env"}, nil),
+				pushCommit("c2", nil, nil, []string{"a.env"}),
+				pushCommit("c3", []string{"a.env"}, nil, nil),
+			},
+			wantSources: "a.env@c3",
+		},
+		{
+			name: "a file changed then removed is only removed",
+			commits: []interface{}{
+				pushCommit("c1", nil, []string{"a.env", "b.env", "c.env"}, nil),
+				pushCommit("c2", nil, nil, []string{"a.env"}),
+				pushCommit("c3", nil, []string{"c.env"}, nil),
+			},
+			wantSources: "b.env@c1 c.env@c3",
+			wantRemoved: "a.env@c2",
+		},
+		{
+			name: "schema files are neither synced nor removed",
+			commits: []interface{}{
+				pushCommit("c1", []string{"a.env.schema.json"}, []string{"a.env"}, []string{"b.env.schema.json"}),
+			},
+			wantSources: "a.env@c1",
+		},
+		{
+			name: "malformed commits are skipped",
+			commits: []interface{}{
+				"not a commit",
+				pushCommit("c2", nil, []string{"a.env"}, nil),
+			},
+			wantSources: "a.env@c2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, removed := coalescePush("octo/app", "c0", "alice", tt.commits)
+			if got := sourceSummary(sources); got != tt.wantSources {
+				t.Errorf("sources = %q, want %q", got, tt.wantSources)
+			}
+			if got := sourceSummary(removed); got != tt.wantRemoved {
+				t.Errorf("removed = %q, want %q", got, tt.wantRemoved)
+			}
+			for _, source := range append(sources, removed...) {
+				if source.Repository != "octo/app" || source.Parent != "c0" || source.Actor != "alice" {
+					t.Errorf("source %+v does not carry the push", source)
+				}
+			}
+			for _, source := range sources {
+				if source.Ref != source.SHA {
+					t.Errorf("source %s is read at %s, want its commit %s", source.Path, source.Ref, source.SHA)
+				}
+			}
+		})
+	}
+}
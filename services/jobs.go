@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"env-updater/core"
+	"env-updater/store"
+)
+
+// Job tracks a pipeline run queued for synced files until it finishes
+type Job struct {
+	Id         string       `json:"id"`
+	Repository string       `json:"repository,omitempty"`
+	SHA        string       `json:"sha,omitempty"` // commit of the last file that needed the run
+	Project    string       `json:"project"`
+	Files      []JobFile    `json:"files"`
+	Run        *PipelineRun `json:"run"`
+	Status     string       `json:"status"` // "running", "succeeded", "failed", "canceled" or "timed_out"
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// JobFile is a file whose upload led to a job's run
+type JobFile struct {
+	Path   string `json:"path"`
+	Target string `json:"target"`
+}
+
+// maxJobs bounds the in-memory job registry, the oldest jobs are dropped first
+const maxJobs = 1000
+
+var jobs = &jobRegistry{jobs: map[string]*Job{}}
+
+// jobRegistry keeps recent jobs in memory
+type jobRegistry struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+}
+
+func (r *jobRegistry) add(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saveJob(job)
+
+	r.jobs[job.Id] = job
+	r.order = append(r.order, job.Id)
+	if len(r.order) > maxJobs {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// update applies fn to a job under the registry lock
+func (r *jobRegistry) update(id string, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+		saveJob(job)
+	}
+}
+
+// saveJob persists a job to the store, if one is configured
+func saveJob(job *Job) {
+	if err := store.Default().SaveJob(job.Id, job.StartedAt, job); err != nil {
+		log.Printf("Failed to save job %s: %v", job.Id, err)
+	}
+}
+
+// GetJob returns a copy of a job by id, looking in the store for jobs no longer in memory
+func GetJob(id string) (Job, bool) {
+	jobs.mu.Lock()
+	job, ok := jobs.jobs[id]
+	if ok {
+		copied := copyJob(job)
+		jobs.mu.Unlock()
+		return copied, true
+	}
+	jobs.mu.Unlock()
+
+	var stored Job
+	if ok, err := store.Default().LoadJob(id, &stored); err != nil {
+		log.Printf("Failed to load job %s: %v", id, err)
+	} else if ok {
+		return stored, true
+	}
+	return Job{}, false
+}
+
+// ListJobs returns copies of the most recent jobs, newest first
+func ListJobs(limit int) []Job {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+
+	var list []Job
+	for i := len(jobs.order) - 1; i >= 0 && (limit <= 0 || len(list) < limit); i-- {
+		list = append(list, copyJob(jobs.jobs[jobs.order[i]]))
+	}
+	return list
+}
+
+func copyJob(job *Job) Job {
+	copied := *job
+	copied.Files = append([]JobFile{}, job.Files...)
+	if job.Run != nil {
+		run := *job.Run
+		copied.Run = &run
+	}
+	return copied
+}
+
+func newJobId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// watchPipelineRun registers a job for a queued run and polls it in the background until it
+// completes or PIPELINE_RUN_TIMEOUT (default 1h) passes. The commit status of each file is
+// updated with the final result. It returns nil when PIPELINE_RUN_WATCH=false.
+func watchPipelineRun(project string, files []triggerFile, run *PipelineRun) *Job {
+	if !core.EnvBool("PIPELINE_RUN_WATCH", true) {
+		return nil
+	}
+
+	// The job and the poller each keep their own copy of the run, the caller's run is shared with
+	// plans and is never updated, and the job's copy is only changed under the registry lock
+	queued := *run
+	run = &queued
+	jobRun := queued
+
+	last := files[len(files)-1].object.Source
+	job := &Job{
+		Id:         newJobId(),
+		Repository: last.Repository,
+		SHA:        last.SHA,
+		Project:    project,
+		Run:        &jobRun,
+		Status:     "running",
+		StartedAt:  time.Now().UTC(),
+	}
+	for _, file := range files {
+		job.Files = append(job.Files, JobFile{Path: file.object.Source.Path, Target: file.target})
+	}
+	jobs.add(job)
+	watched := copyJob(job)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), core.EnvDuration("PIPELINE_RUN_TIMEOUT", time.Hour))
+		defer cancel()
+
+		status, result, err := pollPipelineRun(ctx, project, run)
+		finished := time.Now().UTC()
+		jobs.update(job.Id, func(job *Job) {
+			job.Status = status
+			job.Run.Result = result
+			job.Run.State = "completed"
+			job.FinishedAt = &finished
+			if err != nil {
+				job.Error = err.Error()
+			}
+		})
+
+		core.IncCounter("pipeline_runs", "project", project, "status", status)
+		recordPipelineRun(watched.StartedAt, project, run, status, files)
+		log.Printf("Pipeline %s run %d for %d files finished: %s", run.Pipeline, run.Id, len(files), status)
+
+		state := "success"
+		if status != "succeeded" {
+			state = "failure"
+		}
+		for _, file := range files {
+			reportCommitStatus(context.Background(), file.object.Source, state,
+				fmt.Sprintf("Uploaded to %s; %s run %d %s", file.target, run.Pipeline, run.Id, status), run.URL)
+		}
+	}()
+
+	return &watched
+}
+
+// pollPipelineRun polls a run with exponential backoff, starting at PIPELINE_POLL_INTERVAL
+// (default 10s) and capped at 2 minutes, and returns the job status and the run result
+func pollPipelineRun(ctx context.Context, project string, run *PipelineRun) (string, string, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	delay := core.EnvDuration("PIPELINE_POLL_INTERVAL", 10*time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "timed_out", "", fmt.Errorf("run did not complete in time")
+		case <-time.After(delay):
+		}
+
+		current, err := getPipelineRun(ctx, pat, org, project, run.PipelineId, run.Id)
+		if err != nil {
+			log.Printf("Failed to poll pipeline run %d: %v", run.Id, err)
+		} else if current.State == "completed" {
+			switch current.Result {
+			case "succeeded", "failed", "canceled":
+				return current.Result, current.Result, nil
+			default:
+				return "failed", current.Result, nil
+			}
+		}
+
+		if delay *= 2; delay > 2*time.Minute {
+			delay = 2 * time.Minute
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"env-updater/sinks"
+)
+
+// notification is posted to NOTIFY_WEBHOOK_URL. Text makes it usable as a Slack or Teams incoming webhook.
+type notification struct {
+	Text       string   `json:"text"`
+	Repository string   `json:"repository,omitempty"`
+	SHA        string   `json:"sha,omitempty"`
+	Path       string   `json:"path"`
+	Target     string   `json:"target"`
+	Diff       *KeyDiff `json:"diff,omitempty"`
+}
+
+// notifyFileSynced reports a synced file and its key changes to NOTIFY_WEBHOOK_URL, if configured
+func notifyFileSynced(ctx context.Context, source sinks.Source, filePlan FilePlan) {
+	webhookURL := os.Getenv("NOTIFY_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+
+	text := fmt.Sprintf("%s updated %s", source.Path, filePlan.Target)
+	if source.SHA != "" {
+		text += fmt.Sprintf(" from %s@%.7s", source.Repository, source.SHA)
+	}
+	if filePlan.Diff != nil {
+		text += ": " + filePlan.Diff.String()
+	}
+
+	payloadBytes, err := json.Marshal(notification{
+		Text:       text,
+		Repository: source.Repository,
+		SHA:        source.SHA,
+		Path:       source.Path,
+		Target:     filePlan.Target,
+		Diff:       filePlan.Diff,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal notification for %s: %v", source.Path, err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		log.Printf("Failed to create notification request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Failed to send notification for %s: %v", source.Path, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Notification for %s failed with status %d", source.Path, resp.StatusCode)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"env-updater/core"
+	"env-updater/sinks"
+)
+
+// Plan lists the changes resolved for a webhook event
+type Plan struct {
+	Repository string     `json:"repository"`
+	DryRun     bool       `json:"dry_run"`
+	Files      []FilePlan `json:"files"`
+}
+
+// FilePlan describes the changes for a single file
+type FilePlan struct {
+	Path             string            `json:"path"`
+	Project          string            `json:"project,omitempty"`
+	Sink             string            `json:"sink"`
+	Target           string            `json:"target"`
+	Key              string            `json:"key"` // sink kind, location and object name, see targetKey
+	SecureFileName   string            `json:"secure_file_name,omitempty"`
+	KeysAdded        []string          `json:"keys_added,omitempty"`
+	KeysRemoved      []string          `json:"keys_removed,omitempty"`
+	DryRun           bool              `json:"dry_run"`
+	Action           string            `json:"action,omitempty"` // "replace", "upload", "unchanged", "superseded", "delete" or "sync" when the target was not read
+	Pipeline         *PipelineMatch    `json:"pipeline,omitempty"`
+	Run              *PipelineRun      `json:"run,omitempty"`               // run queued after the upload
+	Job              string            `json:"job,omitempty"`               // id of the job following the run, see GetJob
+	TriggerScheduled bool              `json:"trigger_scheduled,omitempty"` // the run is deferred by TRIGGER_DEBOUNCE
+	Permissions      string            `json:"permissions,omitempty"`
+	Issues           []ValidationIssue `json:"issues,omitempty"`
+	Diff             *KeyDiff          `json:"diff,omitempty"` // key changes since the previous commit
+	Error            string            `json:"error,omitempty"`
+}
+
+// PipelineMatch is the pipeline selected for a file by calculateMatchScore
+type PipelineMatch struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+}
+
+// targetKey identifies an object in its sink independently of routes and display names
+func targetKey(sink sinks.Sink, name string) string {
+	return sink.Kind() + ":" + sink.Location() + "/" + name
+}
+
+// newFilePlan describes the target of an object in its sink
+func newFilePlan(route Route, sink sinks.Sink, obj sinks.Object) FilePlan {
+	filePlan := FilePlan{
+		Path:    obj.Source.Path,
+		Project: route.Project,
+		Sink:    sink.Kind(),
+		Target:  sink.Describe(obj.Name),
+		Key:     targetKey(sink, obj.Name),
+	}
+	if route.SinkName() == SinkSecureFile {
+		filePlan.SecureFileName = obj.Name
+	}
+	return filePlan
+}
+
+// planFile resolves what would happen to a file using only read-only calls. Without readTarget
+// the current content of the target is not compared, so that the plan reveals nothing about it.
+func planFile(ctx context.Context, route Route, sink sinks.Sink, obj sinks.Object, matchPart string, readTarget bool) FilePlan {
+	filePlan := newFilePlan(route, sink, obj)
+	filePlan.DryRun = true
+
+	if !readTarget {
+		filePlan.Action = "sync"
+		return planPipeline(ctx, route, matchPart, filePlan)
+	}
+
+	recorded, exists, err := sink.Hash(ctx, obj.Name)
+	if err != nil {
+		filePlan.Error = fmt.Sprintf("failed to check %s: %v", filePlan.Target, err)
+		return filePlan
+	}
+	if exists && recorded == core.TargetHash(obj.Content) {
+		filePlan.Action = "unchanged" // Nothing would be uploaded or triggered
+		return filePlan
+	}
+	if exists {
+		filePlan.Action = "replace"
+	} else {
+		filePlan.Action = "upload"
+	}
+
+	if planner, ok := sink.(sinks.Planner); ok {
+		change, err := planner.Plan(ctx, obj)
+		if err != nil {
+			filePlan.Error = fmt.Sprintf("failed to plan %s: %v", filePlan.Target, err)
+			return filePlan
+		}
+		filePlan.KeysAdded = change.KeysAdded
+		filePlan.KeysRemoved = change.KeysRemoved
+	}
+	return planPipeline(ctx, route, matchPart, filePlan)
+}
+
+// planPipeline adds the pipeline that would be triggered and authorized to a file plan
+func planPipeline(ctx context.Context, route Route, matchPart string, filePlan FilePlan) FilePlan {
+	if route.Project == "" {
+		return filePlan
+	}
+
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	if pat == "" || org == "" {
+		filePlan.Error = "missing environment variables: AZURE_DEVOPS_PAT or AZURE_DEVOPS_ORG"
+		return filePlan
+	}
+
+	pipeline, err := findBestPipeline(ctx, pat, org, route.Project, matchPart)
+	if err != nil {
+		filePlan.Error = fmt.Sprintf("failed to resolve pipeline: %v", err)
+		return filePlan
+	}
+	pipelineIds := append([]int{}, route.Pipelines...)
+	if pipeline != nil {
+		filePlan.Pipeline = pipeline
+		pipelineIds = append(pipelineIds, pipeline.Id)
+	}
+	if len(pipelineIds) > 0 {
+		filePlan.Permissions = fmt.Sprintf("authorize pipelines %v on %s and revoke access for all other pipelines",
+			pipelineIds, filePlan.Target)
+	}
+
+	return filePlan
+}
+
+// logFilePlan logs a planned file change in a single line
+func logFilePlan(filePlan FilePlan) {
+	if filePlan.Error != "" {
+		log.Printf("[dry-run] %s -> %s: %s", filePlan.Path, filePlan.Target, filePlan.Error)
+		return
+	}
+
+	if filePlan.Action == "unchanged" {
+		log.Printf("[dry-run] %s -> %s unchanged, nothing to do", filePlan.Path, filePlan.Target)
+		return
+	}
+
+	pipeline := "no matching pipeline"
+	if filePlan.Pipeline != nil {
+		pipeline = fmt.Sprintf("trigger pipeline %s (%d)", filePlan.Pipeline.Name, filePlan.Pipeline.Id)
+	}
+	log.Printf("[dry-run] %s -> %s %s; %s", filePlan.Path, filePlan.Action, filePlan.Target, pipeline)
+}
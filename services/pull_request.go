@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"env-updater/core"
+	"env-updater/sinks"
+)
+
+// pullRequestMarker identifies the plan comment so that it is updated instead of duplicated
+const pullRequestMarker = "<!-- env-updater:plan -->"
+
+// pullRequestActions are the pull_request actions that change the files to preview
+var pullRequestActions = map[string]bool{
+	"opened":           true,
+	"reopened":         true,
+	"synchronize":      true,
+	"ready_for_review": true,
+}
+
+// ProcessPullRequestEvent validates and dry-run plans the routed env files changed by a pull
+// request at its head commit, then posts the plan as a pull request comment. The comment is
+// updated on every push to the pull request. Set PULL_REQUEST_PREVIEW=false to disable it.
+// Pull requests from forks are skipped unless PULL_REQUEST_PREVIEW_FORKS=true.
+func ProcessPullRequestEvent(webhookData map[string]interface{}) (*Plan, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	action, _ := webhookData["action"].(string)
+	if !pullRequestActions[action] || !core.EnvBool("PULL_REQUEST_PREVIEW", true) {
+		return nil, nil
+	}
+
+	repo, ok := webhookData["repository"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no repository data found")
+	}
+	fullName, ok := repo["full_name"].(string)
+	if !ok {
+		return nil, fmt.Errorf("could not extract repository full name")
+	}
+
+	pullRequest, ok := webhookData["pull_request"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no pull request data found")
+	}
+	number, _ := pullRequest["number"].(float64)
+	head, _ := pullRequest["head"].(map[string]interface{})
+	base, _ := pullRequest["base"].(map[string]interface{})
+	headSHA, _ := head["sha"].(string)
+	baseSHA, _ := base["sha"].(string)
+	if number == 0 || headSHA == "" {
+		return nil, fmt.Errorf("could not extract pull request number and head commit")
+	}
+
+	// Pull requests from forks can be opened by anyone, preview them only when allowed
+	headRepo, _ := head["repo"].(map[string]interface{})
+	if headName, _ := headRepo["full_name"].(string); headName != fullName && !core.EnvBool("PULL_REQUEST_PREVIEW_FORKS", false) {
+		log.Printf("Skipping plan for %s#%d from fork %s, set PULL_REQUEST_PREVIEW_FORKS=true to enable", fullName, int(number), headName)
+		return nil, nil
+	}
+
+	paths, err := core.ListPullRequestFiles(ctx, fullName, int(number))
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Repository: fullName, DryRun: true}
+	for _, path := range paths {
+		if isSchemaFile(path) {
+			continue
+		}
+		if _, ok, err := matchRoute(filepath.Base(path)); err != nil {
+			return nil, err
+		} else if !ok {
+			continue // Only files with an explicit route are previewed
+		}
+
+		// Pull request commits, including those from forks, can be read from the base repository
+		source := sinks.Source{Repository: fullName, Ref: headSHA, SHA: headSHA, Parent: baseSHA, Path: path}
+		// The plan is posted publicly, so it must not compare the file with the live target
+		filePlan, err := syncFile(ctx, source, ProcessOptions{DryRun: true, preview: true})
+		if err != nil {
+			log.Printf("Failed to plan %s for %s#%d: %v", path, fullName, int(number), err)
+			if filePlan.Path == "" {
+				filePlan = FilePlan{Path: path, DryRun: true, Error: err.Error()}
+			}
+		}
+		plan.Files = append(plan.Files, filePlan)
+	}
+
+	if len(plan.Files) == 0 {
+		return plan, nil
+	}
+	if err := core.UpsertIssueComment(ctx, fullName, int(number), pullRequestMarker, pullRequestComment(plan, headSHA)); err != nil {
+		return plan, err
+	}
+	log.Printf("Posted plan for %d files on %s#%d", len(plan.Files), fullName, int(number))
+	return plan, nil
+}
+
+// pullRequestComment renders a plan as a Markdown comment
+func pullRequestComment(plan *Plan, headSHA string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n### env-updater plan for %.7s\n\n", pullRequestMarker, headSHA)
+	b.WriteString("| File | Target | Action | Keys | Pipeline |\n|---|---|---|---|---|\n")
+
+	var problems []string
+	for _, file := range plan.Files {
+		action := file.Action
+		if file.Error != "" {
+			action = "**failed**"
+		}
+		keys := ""
+		if file.Diff != nil {
+			keys = file.Diff.String()
+		}
+		pipeline := ""
+		if file.Pipeline != nil {
+			pipeline = fmt.Sprintf("%s (%d)", file.Pipeline.Name, file.Pipeline.Id)
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", file.Path, file.Target, action, keys, pipeline)
+
+		for _, issue := range file.Issues {
+			problems = append(problems, fmt.Sprintf("- `%s` %s: %s", file.Path, issue.Severity, issue))
+		}
+		if file.Error != "" && len(file.Issues) == 0 {
+			problems = append(problems, fmt.Sprintf("- `%s` error: %s", file.Path, file.Error))
+		}
+	}
+
+	if len(problems) > 0 {
+		b.WriteString("\n**Problems**\n\n")
+		b.WriteString(strings.Join(problems, "\n"))
+		b.WriteString("\n")
+	}
+	b.WriteString("\nNothing is uploaded until the pull request is merged.\n")
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"env-updater/core"
+	"env-updater/sinks"
+)
+
+// ReconcileResult reports the outcome of reconciling one repository
+type ReconcileResult struct {
+	Repository string          `json:"repository"`
+	Ref        string          `json:"ref"`
+	DryRun     bool            `json:"dry_run"`
+	Files      []ReconcileFile `json:"files"`
+}
+
+// ReconcileFile is the reconcile outcome for a single routed file
+type ReconcileFile struct {
+	Path    string `json:"path"`
+	Project string `json:"project,omitempty"`
+	Target  string `json:"target"`
+	Status  string `json:"status"`           // "in_sync", "uploaded", "would_upload" or "failed"
+	Reason  string `json:"reason,omitempty"` // why the file was out of sync
+	Error   string `json:"error,omitempty"`
+}
+
+// ReconcileTarget is a repository and ref to reconcile
+type ReconcileTarget struct {
+	Repository string
+	Ref        string
+}
+
+// ReconcileTargets parses RECONCILE_REPOS, a comma-separated list of owner/repo[@ref]
+func ReconcileTargets() []ReconcileTarget {
+	var targets []ReconcileTarget
+	for _, entry := range core.SplitList(os.Getenv("RECONCILE_REPOS")) {
+		repo, ref, _ := strings.Cut(entry, "@")
+		targets = append(targets, ReconcileTarget{Repository: repo, Ref: ref})
+	}
+	return targets
+}
+
+// ReconcileAll reconciles every repository in RECONCILE_REPOS
+func ReconcileAll(ctx context.Context, opts ProcessOptions) ([]*ReconcileResult, error) {
+	targets := ReconcileTargets()
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no repositories configured in RECONCILE_REPOS")
+	}
+
+	var results []*ReconcileResult
+	var failed []string
+	for _, target := range targets {
+		result, err := Reconcile(ctx, target.Repository, target.Ref, opts)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", target.Repository, err))
+			continue
+		}
+		results = append(results, result)
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("reconcile failed for %s", strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// Reconcile walks a repository tree at ref and writes every routed file whose content
+// differs from the hash recorded in its sink. Only files matching an explicit route
+// are considered. Pipelines are not triggered unless opts allow it.
+func Reconcile(ctx context.Context, repoFullName, ref string, opts ProcessOptions) (*ReconcileResult, error) {
+	if core.EnvBool("DRY_RUN", false) {
+		opts.DryRun = true
+	}
+	if ref == "" {
+		ref = core.DefaultRef()
+	}
+
+	routedFiles, err := collectRoutedFiles(ctx, repoFullName, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReconcileResult{Repository: repoFullName, Ref: ref, DryRun: opts.DryRun}
+	opts.triggers = newTriggerBatch(opts)
+
+	for _, routed := range routedFiles {
+		file := ReconcileFile{
+			Path:    routed.Path,
+			Project: routed.Route.Project,
+			Target:  routed.Target(),
+		}
+
+		if routed.Err != nil {
+			file.Status = "failed"
+			file.Error = routed.Err.Error()
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		recorded, exists, err := routed.Sink.Hash(ctx, routed.Name())
+		if err != nil {
+			file.Status = "failed"
+			file.Error = err.Error()
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		file.Reason = driftReason(recorded, exists, routed.Hash)
+		if file.Reason == "" {
+			file.Status = "in_sync"
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		if opts.DryRun || routed.Route.DryRun {
+			file.Status = "would_upload"
+			result.Files = append(result.Files, file)
+			continue
+		}
+
+		source := sinks.Source{Repository: repoFullName, Ref: ref, Path: routed.Path}
+		if _, err := syncContent(ctx, source, routed.Content, opts); err != nil {
+			file.Status = "failed"
+			file.Error = err.Error()
+		} else {
+			file.Status = "uploaded"
+		}
+		result.Files = append(result.Files, file)
+	}
+
+	// Each affected pipeline runs once, after all of its files were uploaded
+	for path, trigger := range opts.triggers.flush(ctx) {
+		if trigger.Err != nil {
+			log.Printf("Reconcile %s@%s: failed to trigger pipeline for %s: %v", repoFullName, ref, path, trigger.Err)
+		}
+	}
+
+	for _, file := range result.Files {
+		if file.Status != "in_sync" {
+			log.Printf("Reconcile %s@%s: %s %s -> %s %s", repoFullName, ref, file.Status, file.Path, file.Target, file.Reason)
+		}
+	}
+	return result, nil
+}
+
+// driftReason explains why a sink's recorded hash does not match the expected one, or returns "" when it does
+func driftReason(recorded string, exists bool, expectedHash string) string {
+	if !exists {
+		return "missing"
+	}
+	if recorded == "" {
+		return "no_hash"
+	}
+	if recorded != expectedHash {
+		return "hash_mismatch"
+	}
+	return ""
+}
+
+// StartReconcileLoop reconciles the configured repositories every interval until ctx is cancelled
+func StartReconcileLoop(ctx context.Context, interval time.Duration) {
+	log.Printf("Scheduled reconcile every %s for %d repositories", interval, len(ReconcileTargets()))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				opts := ProcessOptions{SkipTrigger: !core.EnvBool("RECONCILE_TRIGGER_PIPELINES", false)}
+				if _, err := ReconcileAll(ctx, opts); err != nil {
+					log.Printf("Scheduled reconcile error: %v", err)
+				}
+			}
+		}
+	}()
+}
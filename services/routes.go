@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"env-updater/sinks"
+)
+
+// Route maps files whose base name starts with Prefix to a sink, by default
+// a secure file in an Azure DevOps project
+type Route struct {
+	Prefix  string `json:"prefix"`
+	Project string `json:"project,omitempty"` // Azure DevOps project holding the pipelines to trigger
+	DryRun  bool   `json:"dry_run,omitempty"` // plan changes for this route without applying them
+
+	// Sink selects where the file goes: "secure_file" (default) uploads it as-is,
+	// "variable_group" parses it as dotenv into the variables of a variable group,
+	// "github_actions", "gitlab", "vault", "kubernetes" and "local" use the matching
+	// configuration block below.
+	Sink          string   `json:"sink,omitempty"`
+	VariableGroup string   `json:"variable_group,omitempty"` // group name, defaults to the file name
+	SecretKeys    []string `json:"secret_keys,omitempty"`    // key patterns stored as secrets, defaults to all keys
+	Pipelines     []int    `json:"pipelines,omitempty"`      // pipeline ids authorized on the target besides the matched one
+
+	// Run sets the parameters of the pipeline runs triggered for this route
+	Run *RunConfig `json:"run,omitempty"`
+
+	// Schema is enforced before upload, together with any <file>.schema.json next to the env file
+	Schema *Schema `json:"schema,omitempty"`
+
+	GitHubActions *sinks.GitHubActionsConfig `json:"github_actions,omitempty"`
+	GitLab        *sinks.GitLabConfig        `json:"gitlab,omitempty"`
+	Vault         *sinks.VaultConfig         `json:"vault,omitempty"`
+	Kubernetes    *sinks.KubernetesConfig    `json:"kubernetes,omitempty"`
+	Local         *sinks.LocalConfig         `json:"local,omitempty"`
+}
+
+// RunConfig customizes the payload of triggered pipeline runs. Values may use the
+// placeholders {file_name}, {path}, {repository}, {sha}, {ref}, {project} and {target}.
+type RunConfig struct {
+	RefName            string            `json:"ref_name,omitempty"` // branch of the pipeline's own repository, e.g. refs/heads/main
+	TemplateParameters map[string]string `json:"template_parameters,omitempty"`
+	Variables          map[string]string `json:"variables,omitempty"` // must be settable at queue time in the pipeline
+	StagesToSkip       []string          `json:"stages_to_skip,omitempty"`
+}
+
+// resolve returns a copy of the configuration with placeholders replaced for obj, or nil when unset
+func (c *RunConfig) resolve(route Route, sink sinks.Sink, obj sinks.Object) *RunConfig {
+	if c == nil {
+		return nil
+	}
+
+	replacer := strings.NewReplacer(
+		"{file_name}", obj.Name,
+		"{path}", obj.Source.Path,
+		"{repository}", obj.Source.Repository,
+		"{sha}", obj.Source.SHA,
+		"{ref}", obj.Source.Ref,
+		"{project}", route.Project,
+		"{target}", sink.Describe(obj.Name),
+	)
+	resolveMap := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		resolved := make(map[string]string, len(values))
+		for key, value := range values {
+			resolved[key] = replacer.Replace(value)
+		}
+		return resolved
+	}
+
+	return &RunConfig{
+		RefName:            replacer.Replace(c.RefName),
+		TemplateParameters: resolveMap(c.TemplateParameters),
+		Variables:          resolveMap(c.Variables),
+		StagesToSkip:       c.StagesToSkip,
+	}
+}
+
+// Sink names accepted in Route.Sink
+const (
+	SinkSecureFile    = "secure_file"
+	SinkVariableGroup = "variable_group"
+	SinkGitHubActions = "github_actions"
+	SinkGitLab        = "gitlab"
+	SinkVault         = "vault"
+	SinkKubernetes    = "kubernetes"
+	SinkLocal         = "local"
+)
+
+// SinkName returns the route's sink, defaulting to secure files
+func (r Route) SinkName() string {
+	if r.Sink == "" {
+		return SinkSecureFile
+	}
+	return r.Sink
+}
+
+// defaultProject is used when no route matches a filename
+const defaultProject = "DefaultProject"
+
+// defaultRoutes are used when ROUTES_FILE is not set
+var defaultRoutes = []Route{
+	{Prefix: "frontend_", Project: "gamepride-frontend"},
+	{Prefix: "api_", Project: "gamepride-api"},
+	{Prefix: "admin_", Project: "gamepride-admin"},
+}
+
+var (
+	routesOnce   sync.Once
+	loadedRoutes []Route
+	routesErr    error
+)
+
+// Routes returns the configured routes, loading ROUTES_FILE on first use.
+// The file holds a JSON array of routes, checked in order.
+func Routes() ([]Route, error) {
+	routesOnce.Do(func() {
+		path := os.Getenv("ROUTES_FILE")
+		if path == "" {
+			loadedRoutes = defaultRoutes
+			return
+		}
+		loadedRoutes, routesErr = loadRoutesFile(path)
+	})
+	return loadedRoutes, routesErr
+}
+
+// loadRoutesFile reads and validates a routes file
+func loadRoutesFile(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file %s: %v", path, err)
+	}
+
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse routes file %s: %v", path, err)
+	}
+
+	for i, route := range routes {
+		if err := route.validate(); err != nil {
+			return nil, fmt.Errorf("route %d in %s: %v", i, path, err)
+		}
+	}
+	return routes, nil
+}
+
+// validate checks that a route has the configuration its sink needs
+func (r Route) validate() error {
+	if r.Prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+
+	if r.Schema != nil {
+		if err := r.Schema.validate(); err != nil {
+			return fmt.Errorf("schema: %v", err)
+		}
+	}
+
+	switch r.SinkName() {
+	case SinkSecureFile, SinkVariableGroup:
+		if r.Project == "" {
+			return fmt.Errorf("project is required for sink %s", r.SinkName())
+		}
+	case SinkGitHubActions:
+		if r.GitHubActions == nil || r.GitHubActions.Repository == "" {
+			return fmt.Errorf("github_actions configuration with a repository is required for sink github_actions")
+		}
+	case SinkGitLab:
+		if r.GitLab == nil {
+			return fmt.Errorf("gitlab configuration is required for sink gitlab")
+		}
+	case SinkVault:
+		if r.Vault == nil || r.Vault.Path == "" {
+			return fmt.Errorf("vault configuration with a path is required for sink vault")
+		}
+	case SinkKubernetes:
+		if r.Kubernetes == nil || r.Kubernetes.Namespace == "" {
+			return fmt.Errorf("kubernetes configuration with a namespace is required for sink kubernetes")
+		}
+	case SinkLocal:
+		if r.Local == nil {
+			return fmt.Errorf("local configuration is required for sink local")
+		}
+	default:
+		return fmt.Errorf("unknown sink %q", r.Sink)
+	}
+	return nil
+}
+
+// newSink creates the sink a route writes to
+func newSink(ctx context.Context, route Route) (sinks.Sink, error) {
+	switch route.SinkName() {
+	case SinkSecureFile:
+		return sinks.NewAzureSecureFile(route.Project)
+	case SinkVariableGroup:
+		return sinks.NewAzureVariableGroup(route.Project, route.VariableGroup, sinks.SecretPolicy(route.SecretKeys))
+	case SinkGitHubActions:
+		if route.GitHubActions == nil {
+			return nil, fmt.Errorf("github_actions configuration is required for sink github_actions")
+		}
+		return sinks.NewGitHubActions(ctx, *route.GitHubActions)
+	case SinkGitLab:
+		if route.GitLab == nil {
+			return nil, fmt.Errorf("gitlab configuration is required for sink gitlab")
+		}
+		return sinks.NewGitLabVariable(*route.GitLab)
+	case SinkVault:
+		if route.Vault == nil {
+			return nil, fmt.Errorf("vault configuration is required for sink vault")
+		}
+		return sinks.NewVault(ctx, *route.Vault)
+	case SinkKubernetes:
+		if route.Kubernetes == nil {
+			return nil, fmt.Errorf("kubernetes configuration is required for sink kubernetes")
+		}
+		return sinks.NewKubernetes(*route.Kubernetes)
+	case SinkLocal:
+		if route.Local == nil {
+			return nil, fmt.Errorf("local configuration is required for sink local")
+		}
+		return sinks.NewLocalDirectory(*route.Local)
+	}
+	return nil, fmt.Errorf("unknown sink %q", route.Sink)
+}
+
+// routeForFile maps a filename to the first route whose prefix matches it
+func routeForFile(filename string) (Route, error) {
+	route, ok, err := matchRoute(filename)
+	if err != nil {
+		return Route{}, err
+	}
+	if !ok {
+		// Default project if no match is found
+		return Route{Project: defaultProject}, nil
+	}
+	return route, nil
+}
+
+// matchRoute returns the first route whose prefix matches filename, reporting false when none does
+func matchRoute(filename string) (Route, bool, error) {
+	routes, err := Routes()
+	if err != nil {
+		return Route{}, false, err
+	}
+
+	for _, route := range routes {
+		if strings.HasPrefix(filename, route.Prefix) {
+			return route, true, nil
+		}
+	}
+	return Route{}, false, nil
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"env-updater/core"
+	"env-updater/sinks"
+	"github.com/joho/godotenv"
+)
+
+// schemaFileSuffix is appended to an env file's path to find its schema in the repository,
+// e.g. config/api_prod.env.schema.json
+const schemaFileSuffix = ".schema.json"
+
+// Schema declares the keys an env file must, may and must not contain
+type Schema struct {
+	Required  []string           `json:"required,omitempty"`  // keys that must be present and non-empty
+	Allowed   []string           `json:"allowed,omitempty"`   // key patterns; when set, any other key is a violation
+	Keys      map[string]KeyRule `json:"keys,omitempty"`      // value rules by key
+	Forbidden []ForbiddenRule    `json:"forbidden,omitempty"` // keys or values that must not appear
+
+	// Set by merge: a key must match every allow list and its value every rule
+	allowLists []allowList
+	keyRules   map[string][]KeyRule
+}
+
+// allowList is the allow list of one schema, which also allows that schema's required keys
+type allowList struct {
+	patterns []string
+	required []string
+}
+
+// KeyRule constrains the value of a key
+type KeyRule struct {
+	Type    string `json:"type,omitempty"`    // "string" (default), "url", "int" or "bool"
+	Pattern string `json:"pattern,omitempty"` // regular expression the whole value must match
+}
+
+// ForbiddenRule rejects a key, or a key with a value matching Value
+type ForbiddenRule struct {
+	Key    string `json:"key"`             // key pattern, e.g. "DEBUG" or "*_TEST_*"
+	Value  string `json:"value,omitempty"` // regular expression; when empty the key itself is forbidden
+	Reason string `json:"reason,omitempty"`
+}
+
+// validate checks that the schema's types and regular expressions are usable
+func (s *Schema) validate() error {
+	for key, rule := range s.Keys {
+		switch rule.Type {
+		case "", "string", "url", "int", "bool":
+		default:
+			return fmt.Errorf("key %s: unknown type %q", key, rule.Type)
+		}
+		if _, err := compileFullMatch(rule.Pattern); err != nil {
+			return fmt.Errorf("key %s: invalid pattern: %v", key, err)
+		}
+	}
+	for _, rule := range s.Forbidden {
+		if rule.Key == "" {
+			return fmt.Errorf("forbidden rule without a key")
+		}
+		if _, err := compileFullMatch(rule.Value); err != nil {
+			return fmt.Errorf("forbidden %s: invalid value pattern: %v", rule.Key, err)
+		}
+	}
+	return nil
+}
+
+// merge returns a schema enforcing the rules of both s and other, so that a repository
+// schema can only tighten the route's: allow lists and key rules must all hold
+func (s *Schema) merge(other *Schema) *Schema {
+	if s == nil {
+		return other
+	}
+	if other == nil {
+		return s
+	}
+
+	merged := &Schema{
+		Required:   append(append([]string{}, s.Required...), other.Required...),
+		Forbidden:  append(append([]ForbiddenRule{}, s.Forbidden...), other.Forbidden...),
+		allowLists: append(s.allowedLists(), other.allowedLists()...),
+		keyRules:   map[string][]KeyRule{},
+	}
+	for _, schema := range []*Schema{s, other} {
+		for key, rules := range schema.rules() {
+			merged.keyRules[key] = append(merged.keyRules[key], rules...)
+		}
+	}
+	return merged
+}
+
+// allowedLists returns the allow lists a key must match, none when any key is allowed
+func (s *Schema) allowedLists() []allowList {
+	if s.allowLists != nil {
+		return append([]allowList{}, s.allowLists...)
+	}
+	if len(s.Allowed) > 0 {
+		return []allowList{{patterns: s.Allowed, required: s.Required}}
+	}
+	return nil
+}
+
+// rules returns the value rules by key
+func (s *Schema) rules() map[string][]KeyRule {
+	if s.keyRules != nil {
+		return s.keyRules
+	}
+	rules := make(map[string][]KeyRule, len(s.Keys))
+	for key, rule := range s.Keys {
+		rules[key] = []KeyRule{rule}
+	}
+	return rules
+}
+
+// Check returns a violation for every rule the values break. Values are never included in messages.
+func (s *Schema) Check(values map[string]string) []ValidationIssue {
+	var issues []ValidationIssue
+	addIssue := func(key, rule, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Key: key, Rule: rule, Severity: "error", Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, key := range s.Required {
+		if values[key] == "" {
+			addIssue(key, "missing_required", "required key %s is missing or empty", key)
+		}
+	}
+
+	allowLists := s.allowedLists()
+	rules := s.rules()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := values[key]
+
+		for _, allowed := range allowLists {
+			if !matchesAnyPattern(key, allowed.patterns) && !contains(allowed.required, key) {
+				addIssue(key, "unknown_key", "key %s is not allowed by the schema", key)
+				break
+			}
+		}
+
+		for _, rule := range rules[key] {
+			if err := checkType(rule.Type, value); err != nil {
+				addIssue(key, "invalid_type", "value of %s is not a valid %s", key, rule.Type)
+			}
+			if re, _ := compileFullMatch(rule.Pattern); re != nil && !re.MatchString(value) {
+				addIssue(key, "pattern_mismatch", "value of %s does not match %s", key, rule.Pattern)
+			}
+		}
+
+		for _, rule := range s.Forbidden {
+			if !matchesAnyPattern(key, []string{rule.Key}) {
+				continue
+			}
+			reason := ""
+			if rule.Reason != "" {
+				reason = ": " + rule.Reason
+			}
+			if rule.Value == "" {
+				addIssue(key, "forbidden_key", "key %s is forbidden%s", key, reason)
+			} else if re, _ := compileFullMatch(rule.Value); re != nil && re.MatchString(value) {
+				addIssue(key, "forbidden_value", "value of %s matches forbidden %s%s", key, rule.Value, reason)
+			}
+		}
+	}
+	return issues
+}
+
+// checkType reports whether value parses as the given type
+func checkType(valueType, value string) error {
+	switch valueType {
+	case "int":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err
+	case "bool":
+		_, err := strconv.ParseBool(value)
+		return err
+	case "url":
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("missing scheme or host")
+		}
+	}
+	return nil
+}
+
+// compileFullMatch compiles a pattern anchored to the whole value, or returns nil for an empty pattern
+func compileFullMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// matchesAnyPattern reports whether key matches one of the glob patterns
+func matchesAnyPattern(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isSchemaFile reports whether a repository path is a schema file rather than an env file
+func isSchemaFile(filePath string) bool {
+	return strings.HasSuffix(filePath, schemaFileSuffix)
+}
+
+// schemaFor combines the route's schema with the schema file stored next to the env file, if any.
+// Repository schema files are looked up unless REPO_SCHEMA_FILES=false.
+func schemaFor(ctx context.Context, route Route, source sinks.Source) (*Schema, error) {
+	schema := route.Schema
+	if source.Repository == "" || !core.EnvBool("REPO_SCHEMA_FILES", true) {
+		return schema, nil
+	}
+
+	ref := source.Ref
+	if ref == "" {
+		ref = source.SHA
+	}
+	schemaPath := source.Path + schemaFileSuffix
+	data, err := core.FetchFileFromGitHubAtRef(ctx, source.Repository, schemaPath, ref)
+	if errors.Is(err, core.ErrFileNotFound) {
+		return schema, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch schema %s: %v", schemaPath, err)
+	}
+
+	var repoSchema Schema
+	if err := json.Unmarshal(data, &repoSchema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %v", schemaPath, err)
+	}
+	if err := repoSchema.validate(); err != nil {
+		return nil, fmt.Errorf("invalid schema %s: %v", schemaPath, err)
+	}
+	return schema.merge(&repoSchema), nil
+}
+
+// checkSchema validates parsed env content against the schema for its route
+func checkSchema(ctx context.Context, route Route, source sinks.Source, content []byte) ([]ValidationIssue, error) {
+	schema, err := schemaFor(ctx, route, source)
+	if err != nil || schema == nil {
+		return nil, err
+	}
+
+	values, err := godotenv.Unmarshal(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source.Path, err)
+	}
+	issues := schema.Check(values)
+	for _, issue := range issues {
+		core.IncCounter("schema_violations", "rule", issue.Rule)
+	}
+	return issues, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// issueSummary lists issues as sorted KEY:rule pairs
+func issueSummary(issues []ValidationIssue) string {
+	var summary []string
+	for _, issue := range issues {
+		summary = append(summary, issue.Key+":"+issue.Rule)
+	}
+	sort.Strings(summary)
+	return strings.Join(summary, " ")
+}
+
+func TestSchemaMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		route  *Schema
+		repo   *Schema
+		values map[string]string
+		want   string
+	}{
+		{
+			name:   "route allow list is kept when the repository schema has none",
+			route:  &Schema{Allowed: []string{"APP_*"}},
+			repo:   &Schema{Required: []string{"APP_NAME"}},
+			values: map[string]string{"APP_NAME": "api", "EXTRA": "1"},
+			want:   "EXTRA:unknown_key",
+		},
+		{
+			name:   "allow lists are intersected when both schemas have one",
+			route:  &Schema{Allowed: []string{"APP_*", "LOG_LEVEL"}},
+			repo:   &Schema{Allowed: []string{"APP_*", "DEBUG"}},
+			values: map[string]string{"APP_NAME": "api", "LOG_LEVEL": "info", "DEBUG": "true"},
+			want:   "DEBUG:unknown_key LOG_LEVEL:unknown_key",
+		},
+		{
+			name:   "keys required by the repository schema do not bypass the route allow list",
+			route:  &Schema{Allowed: []string{"APP_*"}},
+			repo:   &Schema{Required: []string{"EXTRA"}},
+			values: map[string]string{"APP_NAME": "api", "EXTRA": "1"},
+			want:   "EXTRA:unknown_key",
+		},
+		{
+			name:   "repository key rules cannot loosen route rules",
+			route:  &Schema{Keys: map[string]KeyRule{"PORT": {Type: "int"}}},
+			repo:   &Schema{Keys: map[string]KeyRule{"PORT": {Type: "string", Pattern: ".*"}}},
+			values: map[string]string{"PORT": "http"},
+			want:   "PORT:invalid_type",
+		},
+		{
+			name:   "repository key rules tighten route rules",
+			route:  &Schema{Keys: map[string]KeyRule{"PORT": {Type: "int"}}},
+			repo:   &Schema{Keys: map[string]KeyRule{"PORT": {Pattern: "80[0-9]{2}"}}},
+			values: map[string]string{"PORT": "9000"},
+			want:   "PORT:pattern_mismatch",
+		},
+		{
+			name:   "required and forbidden rules of both schemas apply",
+			route:  &Schema{Required: []string{"APP_NAME"}, Forbidden: []ForbiddenRule{{Key: "DEBUG"}}},
+			repo:   &Schema{Required: []string{"APP_ENV"}, Forbidden: []ForbiddenRule{{Key: "*_URL", Value: "http://.*"}}},
+			values: map[string]string{"APP_NAME": "api", "DEBUG": "1", "API_URL": "http://example.com"},
+			want:   "API_URL:forbidden_value APP_ENV:missing_required DEBUG:forbidden_key",
+		},
+		{
+			name:   "values satisfying both schemas pass",
+			route:  &Schema{Allowed: []string{"APP_*", "PORT"}, Keys: map[string]KeyRule{"PORT": {Type: "int"}}},
+			repo:   &Schema{Allowed: []string{"APP_NAME", "PORT"}, Keys: map[string]KeyRule{"PORT": {Pattern: "80[0-9]{2}"}}},
+			values: map[string]string{"APP_NAME": "api", "PORT": "8080"},
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, schema := range []*Schema{tt.route, tt.repo} {
+				if err := schema.validate(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := issueSummary(tt.route.merge(tt.repo).Check(tt.values)); got != tt.want {
+				t.Errorf("issues = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	schema := &Schema{
+		Required: []string{"API_URL"},
+		Allowed:  []string{"API_*"},
+		Keys: map[string]KeyRule{
+			"API_URL":     {Type: "url"},
+			"API_RETRIES": {Type: "int"},
+			"API_DEBUG":   {Type: "bool"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		values map[string]string
+		want   string
+	}{
+		{
+			name:   "valid values",
+			values: map[string]string{"API_URL": "https://api.example.com", "API_RETRIES": "3", "API_DEBUG": "false"},
+		},
+		{
+			name:   "empty required key",
+			values: map[string]string{"API_URL": ""},
+			want:   "API_URL:invalid_type API_URL:missing_required",
+		},
+		{
+			name:   "invalid types",
+			values: map[string]string{"API_URL": "example.com", "API_RETRIES": "three", "API_DEBUG": "maybe"},
+			want:   "API_DEBUG:invalid_type API_RETRIES:invalid_type API_URL:invalid_type",
+		},
+		{
+			name:   "key outside the allow list",
+			values: map[string]string{"API_URL": "https://api.example.com", "SECRET": "x"},
+			want:   "SECRET:unknown_key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issueSummary(schema.Check(tt.values)); got != tt.want {
+				t.Errorf("issues = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
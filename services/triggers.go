@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"env-updater/core"
+	"env-updater/sinks"
+	"env-updater/store"
+)
+
+// triggerBatch collects the pipelines to run for a set of uploaded files so that each
+// pipeline is triggered once, after all of its files have been uploaded
+type triggerBatch struct {
+	mu        sync.Mutex
+	triggers  map[string]*pendingTrigger
+	order     []string
+	immediate bool // ignore TRIGGER_DEBOUNCE, see ProcessOptions.NoDebounce
+}
+
+// pendingTrigger is a pipeline run waiting for the files that need it
+type pendingTrigger struct {
+	key      string
+	route    Route
+	sink     sinks.Sink
+	pipeline PipelineMatch
+	files    []triggerFile
+}
+
+// triggerFile is an uploaded file waiting on a pipeline run
+type triggerFile struct {
+	object sinks.Object
+	target string
+}
+
+// triggerResult is the outcome of the pipeline run for one file
+type triggerResult struct {
+	Run       *PipelineRun
+	Job       string
+	Scheduled bool // the run was deferred by TRIGGER_DEBOUNCE
+	Err       error
+}
+
+func newTriggerBatch(opts ProcessOptions) *triggerBatch {
+	return &triggerBatch{triggers: map[string]*pendingTrigger{}, immediate: opts.NoDebounce}
+}
+
+// add records that obj, uploaded to sink, needs pipeline to run
+func (b *triggerBatch) add(route Route, sink sinks.Sink, obj sinks.Object, pipeline PipelineMatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := route.Project + "/" + strconv.Itoa(pipeline.Id)
+	pending, ok := b.triggers[key]
+	if !ok {
+		pending = &pendingTrigger{key: key, route: route, sink: sink, pipeline: pipeline}
+		b.triggers[key] = pending
+		b.order = append(b.order, key)
+	}
+	pending.addFile(triggerFile{object: obj, target: sink.Describe(obj.Name)})
+}
+
+// addFile adds a file to the trigger, replacing an earlier version of the same path
+func (t *pendingTrigger) addFile(file triggerFile) {
+	for i, existing := range t.files {
+		if existing.object.Source.Path == file.object.Source.Path {
+			t.files[i] = file
+			return
+		}
+	}
+	t.files = append(t.files, file)
+}
+
+// flush triggers every collected pipeline once and returns the result for each file path.
+// With TRIGGER_DEBOUNCE set (e.g. "30s"), runs are deferred and merged with the files of
+// later deliveries that need the same pipeline within the window, unless the batch is immediate.
+func (b *triggerBatch) flush(ctx context.Context) map[string]triggerResult {
+	// Take the pending triggers so the pipeline calls below run without holding the lock
+	b.mu.Lock()
+	triggers, order := b.triggers, b.order
+	b.triggers = map[string]*pendingTrigger{}
+	b.order = nil
+	b.mu.Unlock()
+
+	var debounce time.Duration
+	if !b.immediate {
+		debounce = core.EnvDuration("TRIGGER_DEBOUNCE", 0)
+	}
+	results := map[string]triggerResult{}
+	for _, key := range order {
+		pending := triggers[key]
+		if debounce > 0 {
+			debouncer.schedule(pending, debounce)
+			for _, file := range pending.files {
+				results[file.object.Source.Path] = triggerResult{Scheduled: true}
+			}
+			continue
+		}
+
+		run, job, err := runTrigger(ctx, pending)
+		for _, file := range pending.files {
+			result := triggerResult{Run: run, Err: err}
+			if job != nil {
+				result.Job = job.Id
+			}
+			results[file.object.Source.Path] = result
+		}
+	}
+
+	return results
+}
+
+// runTrigger queues the pipeline run for a pending trigger and reports it on each file's commit
+func runTrigger(ctx context.Context, pending *pendingTrigger) (*PipelineRun, *Job, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+
+	// Placeholders in the run parameters refer to the last file uploaded for the pipeline
+	last := pending.files[len(pending.files)-1].object
+	params := pending.route.Run.resolve(pending.route, pending.sink, last)
+
+	run, err := triggerPipeline(ctx, pat, org, pending.route.Project, pending.pipeline.Id, params)
+	if err != nil {
+		log.Printf("Failed to trigger pipeline %s for %d files: %v", pending.pipeline.Name, len(pending.files), err)
+		for _, file := range pending.files {
+			audit(file.object.Source, auditTarget(ctx, store.AuditEntry{Type: "pipeline_run", Error: err.Error(),
+				Run: &store.AuditRun{PipelineId: pending.pipeline.Id, Pipeline: pending.pipeline.Name}}, pending.route, pending.sink, file.object.Name))
+			reportCommitStatus(ctx, file.object.Source, "failure", "Uploaded to "+file.target+" but the pipeline trigger failed", "")
+		}
+		return nil, nil, err
+	}
+	run.Pipeline = pending.pipeline.Name
+	log.Printf("Successfully triggered pipeline %s for %d files, run %d %s", run.Pipeline, len(pending.files), run.Id, run.URL)
+
+	// Follow the run to completion and report its result when watching is enabled
+	job := watchPipelineRun(pending.route.Project, pending.files, run)
+	startedAt := time.Now().UTC()
+	if job != nil {
+		startedAt = job.StartedAt
+	}
+	recordPipelineRun(startedAt, pending.route.Project, run, "", pending.files)
+	for _, file := range pending.files {
+		audit(file.object.Source, auditTarget(ctx, store.AuditEntry{Type: "pipeline_run",
+			Run: &store.AuditRun{PipelineId: run.PipelineId, Pipeline: run.Pipeline, RunId: run.Id, URL: run.URL}}, pending.route, pending.sink, file.object.Name))
+	}
+	for _, file := range pending.files {
+		if job != nil {
+			reportCommitStatus(ctx, file.object.Source, "pending", fmt.Sprintf("Uploaded to %s; %s run %d in progress", file.target, run.Pipeline, run.Id), run.URL)
+		} else {
+			reportCommitStatus(ctx, file.object.Source, "success", fmt.Sprintf("Uploaded to %s; queued %s run %d", file.target, run.Pipeline, run.Id), run.URL)
+		}
+	}
+	return run, job, nil
+}
+
+// recordPipelineRun stores a queued or finished run, if a store is configured
+func recordPipelineRun(startedAt time.Time, project string, run *PipelineRun, result string, files []triggerFile) {
+	record := store.PipelineRun{
+		Time:       startedAt,
+		Project:    project,
+		PipelineId: run.PipelineId,
+		Pipeline:   run.Pipeline,
+		RunId:      run.Id,
+		URL:        run.URL,
+		Result:     result,
+	}
+	for _, file := range files {
+		record.Paths = append(record.Paths, file.object.Source.Path)
+	}
+	if err := store.Default().SavePipelineRun(record); err != nil {
+		log.Printf("Failed to record pipeline run %d: %v", run.Id, err)
+	}
+}
+
+var debouncer = &triggerDebouncer{pending: map[string]*pendingTrigger{}, timers: map[string]*time.Timer{}}
+
+// triggerDebouncer defers pipeline runs so that deliveries arriving within a window share a single run
+type triggerDebouncer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingTrigger
+	timers  map[string]*time.Timer
+}
+
+// schedule merges a trigger into the one already waiting for the same pipeline and restarts its window
+func (d *triggerDebouncer) schedule(trigger *pendingTrigger, window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if existing, ok := d.pending[trigger.key]; ok {
+		for _, file := range trigger.files {
+			existing.addFile(file)
+		}
+		d.timers[trigger.key].Reset(window)
+		log.Printf("Merged %d files into the pending run of pipeline %s", len(trigger.files), trigger.pipeline.Name)
+		return
+	}
+
+	d.pending[trigger.key] = trigger
+	d.timers[trigger.key] = time.AfterFunc(window, func() { d.fire(trigger.key) })
+	log.Printf("Scheduled pipeline %s to run in %s", trigger.pipeline.Name, window)
+}
+
+// fire triggers a pipeline once its window has passed
+func (d *triggerDebouncer) fire(key string) {
+	d.mu.Lock()
+	trigger, ok := d.pending[key]
+	delete(d.pending, key)
+	delete(d.timers, key)
+	d.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	runTrigger(ctx, trigger)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// ValidationIssue is a problem found in an env file
+type ValidationIssue struct {
+	Line     int    `json:"line,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Rule     string `json:"rule"`     // e.g. "parse_error", "duplicate_key", "conflict_marker"
+	Severity string `json:"severity"` // "error" blocks the upload, "warning" is only reported
+	Message  string `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+	}
+	return i.Message
+}
+
+// validKeyPattern matches the variable names accepted by shells and pipelines
+var validKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// conflictMarkers are the lines git leaves behind in an unresolved merge
+var conflictMarkers = []string{"<<<<<<<", "|||||||", "=======", ">>>>>>>"}
+
+// ValidateEnvFile checks that content is a well-formed dotenv file. It runs
+// godotenv's parser and then scans each line for problems the parser accepts
+// silently: duplicate keys, invalid key names, YAML style "KEY: value" lines,
+// conflict markers and trailing whitespace in unquoted values.
+func ValidateEnvFile(content []byte) []ValidationIssue {
+	var issues []ValidationIssue
+	addIssue := func(line int, key, rule, severity, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Line: line, Key: key, Rule: rule, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, err := godotenv.Unmarshal(string(content)); err != nil {
+		addIssue(0, "", "parse_error", "error", "failed to parse as dotenv: %v", err)
+	}
+
+	seen := map[string]int{}
+	openQuote := byte(0) // quote character of a multi-line value still being read
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if marker := conflictMarker(line); marker != "" {
+			addIssue(lineNumber, "", "conflict_marker", "error", "merge conflict marker %q", marker)
+			continue
+		}
+
+		if openQuote != 0 {
+			if strings.ContainsRune(line, rune(openQuote)) {
+				openQuote = 0
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		trimmed = strings.TrimPrefix(trimmed, "export ")
+
+		eq := strings.Index(trimmed, "=")
+		colon := strings.Index(trimmed, ":")
+		if colon >= 0 && (eq < 0 || colon < eq) {
+			addIssue(lineNumber, strings.TrimSpace(trimmed[:colon]), "yaml_syntax", "error", "uses \"KEY: value\", expected KEY=value (is this a YAML file?)")
+			continue
+		}
+		if eq < 0 {
+			addIssue(lineNumber, "", "invalid_line", "error", "expected KEY=value")
+			continue
+		}
+
+		key := strings.TrimSpace(trimmed[:eq])
+		if !validKeyPattern.MatchString(key) {
+			addIssue(lineNumber, key, "invalid_key", "error", "invalid key name %q", key)
+		}
+		if first, ok := seen[key]; ok {
+			addIssue(lineNumber, key, "duplicate_key", "error", "duplicate key %s, first defined on line %d", key, first)
+		} else {
+			seen[key] = lineNumber
+		}
+
+		value := strings.TrimLeft(trimmed[eq+1:], " \t")
+		if value != "" && (value[0] == '"' || value[0] == '\'') {
+			if !strings.ContainsRune(value[1:], rune(value[0])) {
+				openQuote = value[0]
+			}
+			continue
+		}
+		rawValue := line[strings.Index(line, "=")+1:]
+		if strings.TrimRight(rawValue, " \t") != rawValue {
+			addIssue(lineNumber, key, "trailing_whitespace", "warning", "value of %s has trailing whitespace", key)
+		}
+	}
+
+	if openQuote != 0 {
+		addIssue(0, "", "unterminated_quote", "error", "a quoted value is never closed")
+	}
+	return issues
+}
+
+// conflictMarker returns the merge conflict marker a line starts with, if any
+func conflictMarker(line string) string {
+	for _, marker := range conflictMarkers {
+		if strings.HasPrefix(line, marker) {
+			return marker
+		}
+	}
+	return ""
+}
+
+// hasValidationErrors reports whether any issue blocks the upload
+func hasValidationErrors(issues []ValidationIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// summarizeIssues joins the issues into a single line, errors first
+func summarizeIssues(issues []ValidationIssue) string {
+	var errors, warnings []string
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			errors = append(errors, issue.String())
+		} else {
+			warnings = append(warnings, issue.String())
+		}
+	}
+	return strings.Join(append(errors, warnings...), "; ")
+}
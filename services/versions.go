@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"env-updater/core"
+	"env-updater/sinks"
+	"env-updater/store"
+)
+
+// versionCipher returns the AES-GCM cipher keyed by VERSION_ENCRYPTION_KEY (base64, 16, 24 or 32 bytes),
+// or nil when no key is set and versions are kept as commit references only
+func versionCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv("VERSION_ENCRYPTION_KEY")
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VERSION_ENCRYPTION_KEY: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VERSION_ENCRYPTION_KEY: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordVersion keeps the content uploaded to a target in its version history, the newest
+// VERSION_HISTORY (default 5) versions per target key. Content is encrypted when
+// VERSION_ENCRYPTION_KEY is set, otherwise only its commit is recorded.
+func recordVersion(source sinks.Source, filePlan FilePlan, fileContent []byte) {
+	db := store.Default()
+	keep := core.EnvInt("VERSION_HISTORY", 5)
+	if db == nil || keep <= 0 {
+		return
+	}
+
+	version := store.Version{
+		Key:        filePlan.Key,
+		Target:     filePlan.Target,
+		Repository: source.Repository,
+		Path:       source.Path,
+		Ref:        source.SHA,
+		Hash:       core.ContentHash(fileContent),
+	}
+	if version.Ref == "" {
+		version.Ref = source.Ref
+	}
+
+	var sealed []byte
+	aead, err := versionCipher()
+	if err != nil {
+		log.Printf("Keeping version of %s as a commit reference: %v", filePlan.Target, err)
+	} else if aead != nil {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			log.Printf("Keeping version of %s as a commit reference: %v", filePlan.Target, err)
+		} else {
+			// The target key is bound as additional data, so a version cannot be replayed to another target
+			sealed = aead.Seal(nonce, nonce, fileContent, []byte(version.Key))
+			version.Encrypted = true
+		}
+	}
+	if !version.Encrypted && version.Ref == "" {
+		log.Printf("Not keeping version of %s: no encryption key and no commit to reference", filePlan.Target)
+		return
+	}
+
+	if _, err := db.SaveVersion(version, sealed, keep); err != nil {
+		log.Printf("Failed to record version of %s: %v", filePlan.Target, err)
+	}
+}
+
+// versionContent returns the content of a version, decrypting it or fetching it from its commit
+func versionContent(ctx context.Context, version store.Version, sealed []byte) ([]byte, error) {
+	var content []byte
+	if version.Encrypted {
+		aead, err := versionCipher()
+		if err != nil {
+			return nil, err
+		}
+		if aead == nil {
+			return nil, fmt.Errorf("version %d of %s is encrypted but VERSION_ENCRYPTION_KEY is not set", version.Id, version.Key)
+		}
+		if len(sealed) < aead.NonceSize() {
+			return nil, fmt.Errorf("version %d of %s is truncated", version.Id, version.Key)
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if content, err = aead.Open(nil, nonce, ciphertext, []byte(version.Key)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt version %d of %s: %v", version.Id, version.Key, err)
+		}
+	} else {
+		var err error
+		if content, err = core.FetchFileFromGitHubAtRef(ctx, version.Repository, version.Path, version.Ref); err != nil {
+			return nil, fmt.Errorf("failed to fetch version %d of %s from %s@%s: %v", version.Id, version.Key, version.Repository, version.Ref, err)
+		}
+	}
+
+	if hash := core.ContentHash(content); hash != version.Hash {
+		return nil, fmt.Errorf("version %d of %s has hash %s, expected %s", version.Id, version.Key, hash, version.Hash)
+	}
+	return content, nil
+}
+
+// ListVersions returns the version history of the target with key, as shown in plans, newest first
+func ListVersions(key string) ([]store.Version, error) {
+	db := store.Default()
+	if db == nil {
+		return nil, fmt.Errorf("no store configured, set STORE_PATH")
+	}
+	return db.ListVersions(key)
+}
+
+// Rollback re-uploads a previous version of the target with key and triggers its pipelines again.
+// With version 0 the version before the current one is restored.
+func Rollback(ctx context.Context, key string, version uint64, actor string, opts ProcessOptions) (FilePlan, error) {
+	versions, err := ListVersions(key)
+	if err != nil {
+		return FilePlan{}, err
+	}
+	if len(versions) == 0 {
+		return FilePlan{}, fmt.Errorf("no versions recorded for %s", key)
+	}
+	if version == 0 {
+		if len(versions) < 2 {
+			return FilePlan{}, fmt.Errorf("no previous version recorded for %s", key)
+		}
+		version = versions[1].Id
+	}
+
+	selected, sealed, ok, err := store.Default().LoadVersion(key, version)
+	if err != nil {
+		return FilePlan{}, err
+	}
+	if !ok {
+		return FilePlan{}, fmt.Errorf("version %d of %s not found", version, key)
+	}
+
+	// Refuse to restore when the file now routes to another sink or location
+	route, err := routeForFile(filepath.Base(selected.Path))
+	if err != nil {
+		return FilePlan{}, err
+	}
+	sink, err := newSink(ctx, route)
+	if err != nil {
+		return FilePlan{}, err
+	}
+	if current := targetKey(sink, filepath.Base(selected.Path)); current != key {
+		return FilePlan{}, fmt.Errorf("%s now routes to %s, not %s", selected.Path, current, key)
+	}
+
+	content, err := versionContent(ctx, selected, sealed)
+	if err != nil {
+		return FilePlan{}, err
+	}
+
+	// Diff against the newest version, which is what the target holds now. The commit is
+	// given as Ref rather than SHA so that no status is reported on the old commit.
+	source := sinks.Source{
+		Repository: selected.Repository,
+		Path:       selected.Path,
+		Ref:        selected.Ref,
+		Parent:     versions[0].Ref,
+		Actor:      actor,
+	}
+	log.Printf("Rolling back %s to version %d (%.7s, %s) for %s", key, selected.Id, selected.Ref, selected.Time.Format(time.RFC3339), actor)
+
+	opts.Force = true
+	opts.rollback = true
+	return syncContent(ctx, source, content, opts)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"env-updater/sinks"
+	"env-updater/store"
+)
+
+// useTestStore makes a temporary store the default for the test
+func useTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	db, err := store.Open(filepath.Join(t.TempDir(), "env-updater.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetDefault(db)
+	t.Cleanup(func() {
+		store.SetDefault(nil)
+		db.Close()
+	})
+	return db
+}
+
+func TestVersionContent(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	otherKey := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+
+	tests := []struct {
+		name    string
+		readKey string // VERSION_ENCRYPTION_KEY when the version is read back
+		readAs  string // target key the version is read back as
+		tamper  func(sealed []byte)
+		wantErr string
+	}{
+		{name: "encrypted content round trips", readKey: key, readAs: "local/dir/app.env"},
+		{name: "key is required to read it back", readKey: "", readAs: "local/dir/app.env", wantErr: "VERSION_ENCRYPTION_KEY is not set"},
+		{name: "another key cannot decrypt it", readKey: otherKey, readAs: "local/dir/app.env", wantErr: "failed to decrypt"},
+		{name: "it cannot be replayed to another target", readKey: key, readAs: "local/dir/other.env", wantErr: "failed to decrypt"},
+		{
+			name:    "modified ciphertext is rejected",
+			readKey: key,
+			readAs:  "local/dir/app.env",
+			tamper:  func(sealed []byte) { sealed[len(sealed)-1] ^= 1 },
+			wantErr: "failed to decrypt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useTestStore(t)
+			t.Setenv("VERSION_ENCRYPTION_KEY", key)
+
+			content := []byte("API_KEY=secret\n")
+			source := sinks.Source{Repository: "octo/app", Path: "app.env", SHA: "c1"}
+			recordVersion(source, FilePlan{Key: "local/dir/app.env", Target: "file dir/app.env"}, content)
+
+			versions, err := db.ListVersions("local/dir/app.env")
+			if err != nil || len(versions) != 1 || !versions[0].Encrypted {
+				t.Fatalf("versions = %+v, %v, want one encrypted version", versions, err)
+			}
+			version, sealed, _, err := db.LoadVersion("local/dir/app.env", versions[0].Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(sealed), "secret") {
+				t.Fatalf("content is stored in the clear")
+			}
+			if tt.tamper != nil {
+				tt.tamper(sealed)
+			}
+
+			t.Setenv("VERSION_ENCRYPTION_KEY", tt.readKey)
+			version.Key = tt.readAs
+			got, err := versionContent(context.Background(), version, sealed)
+			if tt.wantErr == "" {
+				if err != nil || string(got) != string(content) {
+					t.Errorf("versionContent() = %q, %v, want %q", got, err, content)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("versionContent() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordVersionKeepsHistory(t *testing.T) {
+	db := useTestStore(t)
+	t.Setenv("VERSION_ENCRYPTION_KEY", "")
+	t.Setenv("VERSION_HISTORY", "2")
+
+	filePlan := FilePlan{Key: "local/dir/app.env", Target: "file dir/app.env"}
+	for i, sha := range []string{"c1", "c2", "c3"} {
+		source := sinks.Source{Repository: "octo/app", Path: "app.env", SHA: sha}
+		recordVersion(source, filePlan, []byte{byte('a' + i)})
+	}
+	// Without a commit and an encryption key there is nothing to restore, so nothing is kept
+	recordVersion(sinks.Source{Repository: "octo/app", Path: "app.env"}, filePlan, []byte("d"))
+
+	versions, err := db.ListVersions(filePlan.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Ref != "c3" || versions[1].Ref != "c2" || versions[0].Encrypted {
+		t.Errorf("versions = %+v, want commit references c3 and c2", versions)
+	}
+}
+
+func TestRollbackNeedsHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		shas    []string
+		version uint64
+		wantErr string
+	}{
+		{name: "no versions", version: 0, wantErr: "no versions recorded"},
+		{name: "no previous version", shas: []string{"c1"}, version: 0, wantErr: "no previous version recorded"},
+		{name: "unknown version", shas: []string{"c1", "c2"}, version: 7, wantErr: "version 7 of local/dir/app.env not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestStore(t)
+			t.Setenv("VERSION_ENCRYPTION_KEY", "")
+			filePlan := FilePlan{Key: "local/dir/app.env", Target: "file dir/app.env"}
+			for i, sha := range tt.shas {
+				recordVersion(sinks.Source{Repository: "octo/app", Path: "app.env", SHA: sha}, filePlan, []byte{byte('a' + i)})
+			}
+
+			_, err := Rollback(context.Background(), filePlan.Key, tt.version, "alice", ProcessOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Rollback() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"env-updater/core"
+)
+
+// AzureSecureFile stores objects as files in an Azure DevOps project's Secure Files library
+type AzureSecureFile struct {
+	PAT          string
+	Organization string
+	Project      string
+}
+
+// NewAzureSecureFile creates a secure file sink for project using AZURE_DEVOPS_PAT and AZURE_DEVOPS_ORG
+func NewAzureSecureFile(project string) (*AzureSecureFile, error) {
+	pat, org, err := azureCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return &AzureSecureFile{PAT: pat, Organization: org, Project: project}, nil
+}
+
+func (s *AzureSecureFile) Kind() string     { return "secure_file" }
+func (s *AzureSecureFile) Location() string { return s.Project }
+
+func (s *AzureSecureFile) Describe(name string) string {
+	return fmt.Sprintf("secure file %s in project %s", name, s.Project)
+}
+
+func (s *AzureSecureFile) Put(ctx context.Context, obj Object) error {
+	_, err := core.UploadSecureFile(ctx, s.PAT, s.Organization, s.Project, obj.Name, obj.Content)
+	return err
+}
+
+func (s *AzureSecureFile) Delete(ctx context.Context, name string) error {
+	secureFile, err := core.FindSecureFile(ctx, s.PAT, s.Organization, s.Project, name)
+	if err != nil || secureFile == nil {
+		return err
+	}
+	return core.DeleteSecureFile(ctx, secureFile.Id, s.PAT, s.Organization, s.Project)
+}
+
+func (s *AzureSecureFile) Hash(ctx context.Context, name string) (string, bool, error) {
+	secureFile, err := core.FindSecureFile(ctx, s.PAT, s.Organization, s.Project, name)
+	if err != nil || secureFile == nil {
+		return "", false, err
+	}
+	return secureFile.Properties[core.ContentHashProperty], true, nil
+}
+
+func (s *AzureSecureFile) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	secureFile, err := core.FindSecureFile(ctx, s.PAT, s.Organization, s.Project, name)
+	if err != nil {
+		return fmt.Errorf("failed to get secure file ID: %v", err)
+	}
+	if secureFile == nil {
+		return fmt.Errorf("secure file not found: %s", name)
+	}
+	return core.AuthorizePipelines(ctx, s.PAT, s.Organization, s.Project, "securefile", secureFile.Id, pipelineIds)
+}
+
+// ObjectId returns the id of the secure file holding name
+func (s *AzureSecureFile) ObjectId(ctx context.Context, name string) (string, error) {
+	secureFile, err := core.FindSecureFile(ctx, s.PAT, s.Organization, s.Project, name)
+	if err != nil || secureFile == nil {
+		return "", err
+	}
+	return secureFile.Id, nil
+}
+
+// List returns the names of all secure files in the project
+func (s *AzureSecureFile) List(ctx context.Context) ([]string, error) {
+	secureFiles, err := core.ListSecureFiles(ctx, s.PAT, s.Organization, s.Project)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secureFiles))
+	for _, secureFile := range secureFiles {
+		names = append(names, secureFile.Name)
+	}
+	return names, nil
+}
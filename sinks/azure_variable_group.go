@@ -0,0 +1,158 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"env-updater/core"
+	"github.com/joho/godotenv"
+)
+
+// AzureVariableGroup stores the keys of a dotenv object as variables of an Azure DevOps variable group
+type AzureVariableGroup struct {
+	PAT          string
+	Organization string
+	Project      string
+	GroupName    string       // fixed group name; when empty the object name is used
+	Secrets      SecretPolicy // keys stored as secret variables
+}
+
+// NewAzureVariableGroup creates a variable group sink using AZURE_DEVOPS_PAT and AZURE_DEVOPS_ORG
+func NewAzureVariableGroup(project, groupName string, secrets SecretPolicy) (*AzureVariableGroup, error) {
+	pat, org, err := azureCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return &AzureVariableGroup{PAT: pat, Organization: org, Project: project, GroupName: groupName, Secrets: secrets}, nil
+}
+
+// groupHashPattern extracts the content hash recorded in a group's description
+var groupHashPattern = regexp.MustCompile(`\(content hash ([0-9a-f]{64})\)`)
+
+func (s *AzureVariableGroup) Kind() string     { return "variable_group" }
+func (s *AzureVariableGroup) Location() string { return s.Project }
+
+func (s *AzureVariableGroup) Describe(name string) string {
+	return fmt.Sprintf("variable group %s in project %s", s.groupName(name), s.Project)
+}
+
+// Put replaces the group's variables with the object's keys, removing keys that disappeared
+func (s *AzureVariableGroup) Put(ctx context.Context, obj Object) error {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+
+	groupName := s.groupName(obj.Name)
+	existing, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, groupName)
+	if err != nil {
+		return err
+	}
+
+	group := core.VariableGroup{
+		Name:        groupName,
+		Description: fmt.Sprintf("Managed by env-updater from %s (content hash %s)", obj.Source.Path, core.TargetHash(obj.Content)),
+		Variables:   make(map[string]core.GroupVariable, len(values)),
+	}
+	if existing != nil {
+		group.Id = existing.Id
+	}
+	for key, value := range values {
+		group.Variables[key] = core.GroupVariable{Value: value, IsSecret: s.Secrets.IsSecret(key)}
+	}
+
+	_, err = core.SaveVariableGroup(ctx, s.PAT, s.Organization, s.Project, group)
+	return err
+}
+
+func (s *AzureVariableGroup) Delete(ctx context.Context, name string) error {
+	existing, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, s.groupName(name))
+	if err != nil || existing == nil {
+		return err
+	}
+	return core.DeleteVariableGroup(ctx, s.PAT, s.Organization, s.Project, existing.Id)
+}
+
+func (s *AzureVariableGroup) Hash(ctx context.Context, name string) (string, bool, error) {
+	existing, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, s.groupName(name))
+	if err != nil || existing == nil {
+		return "", false, err
+	}
+	if match := groupHashPattern.FindStringSubmatch(existing.Description); match != nil {
+		return match[1], true, nil
+	}
+	return "", true, nil
+}
+
+func (s *AzureVariableGroup) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	existing, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, s.groupName(name))
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("variable group not found: %s", s.groupName(name))
+	}
+	return core.AuthorizePipelines(ctx, s.PAT, s.Organization, s.Project, "variablegroup", fmt.Sprint(existing.Id), pipelineIds)
+}
+
+// Plan lists the keys Put would add to or remove from the group
+func (s *AzureVariableGroup) Plan(ctx context.Context, obj Object) (Change, error) {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+
+	existing, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, s.groupName(obj.Name))
+	if err != nil {
+		return Change{}, err
+	}
+
+	var change Change
+	for key := range values {
+		if existing == nil {
+			change.KeysAdded = append(change.KeysAdded, key)
+		} else if _, ok := existing.Variables[key]; !ok {
+			change.KeysAdded = append(change.KeysAdded, key)
+		}
+	}
+	if existing != nil {
+		for key := range existing.Variables {
+			if _, ok := values[key]; !ok {
+				change.KeysRemoved = append(change.KeysRemoved, key)
+			}
+		}
+	}
+	sort.Strings(change.KeysAdded)
+	sort.Strings(change.KeysRemoved)
+	return change, nil
+}
+
+// ObjectId returns the id of the variable group holding name
+func (s *AzureVariableGroup) ObjectId(ctx context.Context, name string) (string, error) {
+	group, err := core.FindVariableGroup(ctx, s.PAT, s.Organization, s.Project, s.groupName(name))
+	if err != nil || group == nil {
+		return "", err
+	}
+	return strconv.Itoa(group.Id), nil
+}
+
+func (s *AzureVariableGroup) groupName(name string) string {
+	if s.GroupName != "" {
+		return s.GroupName
+	}
+	return name
+}
+
+// azureCredentials reads the Azure DevOps PAT and organization from the environment
+func azureCredentials() (string, string, error) {
+	pat := os.Getenv("AZURE_DEVOPS_PAT")
+	org := os.Getenv("AZURE_DEVOPS_ORG")
+	if pat == "" || org == "" {
+		return "", "", fmt.Errorf("missing environment variables: AZURE_DEVOPS_PAT or AZURE_DEVOPS_ORG")
+	}
+	return pat, org, nil
+}
@@ -0,0 +1,351 @@
+package sinks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"env-updater/core"
+	"github.com/google/go-github/v50/github"
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// GitHubActionsConfig configures a GitHub Actions secrets sink
+type GitHubActionsConfig struct {
+	Repository  string `json:"repository"`            // owner/repo whose secrets are written
+	Environment string `json:"environment,omitempty"` // write environment secrets instead of repository secrets
+	Prefix      string `json:"prefix,omitempty"`      // prepended to every secret name
+}
+
+// GitHubActions writes each key of a dotenv object as an encrypted GitHub Actions secret.
+//
+// The key names and keyed content hash of every object are kept in a manifest stored as an
+// Actions variable (ENV_UPDATER_<NAME>), since secret values cannot be read back.
+// The manifest is used to delete secrets whose keys were removed from the file.
+type GitHubActions struct {
+	Config GitHubActionsConfig
+	Client *github.Client
+
+	owner, repo string
+	repoID      int64
+}
+
+// actionsManifest records which secrets were written for an object
+type actionsManifest struct {
+	Hash   string   `json:"hash"` // TargetHash of the content, keyed so that it cannot confirm guesses
+	Source string   `json:"source,omitempty"`
+	Keys   []string `json:"keys"`
+}
+
+// NewGitHubActions creates a GitHub Actions sink using the GitHub client from core
+func NewGitHubActions(ctx context.Context, config GitHubActionsConfig) (*GitHubActions, error) {
+	owner, repo, err := core.SplitRepositoryFullName(config.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := core.NewGitHubClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &GitHubActions{Config: config, Client: client, owner: owner, repo: repo}
+	if config.Environment != "" {
+		repository, _, err := client.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get repository %s: %v", config.Repository, err)
+		}
+		sink.repoID = repository.GetID()
+	}
+	return sink, nil
+}
+
+func (s *GitHubActions) Kind() string { return "github_actions" }
+
+func (s *GitHubActions) Location() string {
+	if s.Config.Environment != "" {
+		return fmt.Sprintf("github:%s/%s", s.Config.Repository, s.Config.Environment)
+	}
+	return "github:" + s.Config.Repository
+}
+
+func (s *GitHubActions) Describe(name string) string {
+	if s.Config.Environment != "" {
+		return fmt.Sprintf("Actions secrets from %s in %s environment %s", name, s.Config.Repository, s.Config.Environment)
+	}
+	return fmt.Sprintf("Actions secrets from %s in %s", name, s.Config.Repository)
+}
+
+// Put encrypts every key with the repository or environment public key and deletes
+// secrets for keys that are no longer present
+func (s *GitHubActions) Put(ctx context.Context, obj Object) error {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+
+	// Refuse the whole object before writing anything, secrets written before a failure
+	// would be missing from the manifest and never cleaned up
+	if err := s.checkSecretNames(values); err != nil {
+		return err
+	}
+
+	publicKey, err := s.publicKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	manifest := actionsManifest{Hash: core.TargetHash(obj.Content), Source: obj.Source.Path}
+	for key, value := range values {
+		secretName := s.secretName(key)
+		encrypted, err := sealValue(publicKey, value)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", secretName, err)
+		}
+		if err := s.putSecret(ctx, &github.EncryptedSecret{Name: secretName, KeyID: publicKey.GetKeyID(), EncryptedValue: encrypted}); err != nil {
+			return fmt.Errorf("failed to write secret %s: %v", secretName, err)
+		}
+		manifest.Keys = append(manifest.Keys, secretName)
+	}
+	sort.Strings(manifest.Keys)
+
+	previous, err := s.manifest(ctx, obj.Name)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		current := make(map[string]bool, len(manifest.Keys))
+		for _, key := range manifest.Keys {
+			current[key] = true
+		}
+		for _, key := range previous.Keys {
+			if !current[key] {
+				if err := s.deleteSecret(ctx, key); err != nil {
+					return fmt.Errorf("failed to delete removed secret %s: %v", key, err)
+				}
+			}
+		}
+	}
+
+	return s.saveManifest(ctx, obj.Name, manifest, previous != nil)
+}
+
+// Delete removes every secret listed in the object's manifest, then the manifest itself
+func (s *GitHubActions) Delete(ctx context.Context, name string) error {
+	manifest, err := s.manifest(ctx, name)
+	if err != nil || manifest == nil {
+		return err
+	}
+	for _, key := range manifest.Keys {
+		if err := s.deleteSecret(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete secret %s: %v", key, err)
+		}
+	}
+	return ignoreNotFound(s.deleteVariable(ctx, s.manifestName(name)))
+}
+
+func (s *GitHubActions) Hash(ctx context.Context, name string) (string, bool, error) {
+	manifest, err := s.manifest(ctx, name)
+	if err != nil || manifest == nil {
+		return "", false, err
+	}
+	return manifest.Hash, true, nil
+}
+
+// Authorize is a no-op, repository secrets are available to every workflow of the repository
+func (s *GitHubActions) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	return nil
+}
+
+// Plan lists the secrets Put would add or remove
+func (s *GitHubActions) Plan(ctx context.Context, obj Object) (Change, error) {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return Change{}, fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+	if err := s.checkSecretNames(values); err != nil {
+		return Change{}, err
+	}
+	manifest, err := s.manifest(ctx, obj.Name)
+	if err != nil {
+		return Change{}, err
+	}
+
+	previous := map[string]bool{}
+	if manifest != nil {
+		for _, key := range manifest.Keys {
+			previous[key] = true
+		}
+	}
+
+	var change Change
+	current := map[string]bool{}
+	for key := range values {
+		secretName := s.secretName(key)
+		current[secretName] = true
+		if !previous[secretName] {
+			change.KeysAdded = append(change.KeysAdded, secretName)
+		}
+	}
+	for key := range previous {
+		if !current[key] {
+			change.KeysRemoved = append(change.KeysRemoved, key)
+		}
+	}
+	sort.Strings(change.KeysAdded)
+	sort.Strings(change.KeysRemoved)
+	return change, nil
+}
+
+// sealValue encrypts a value for GitHub with a libsodium sealed box
+func sealValue(publicKey *github.PublicKey, value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(publicKey.GetKey())
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+	if len(decoded) != 32 {
+		return "", fmt.Errorf("invalid public key length %d", len(decoded))
+	}
+
+	var recipient [32]byte
+	copy(recipient[:], decoded)
+	sealed, err := box.SealAnonymous(nil, []byte(value), &recipient, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// checkSecretNames rejects keys whose secret name is reserved by GitHub
+func (s *GitHubActions) checkSecretNames(values map[string]string) error {
+	var reserved []string
+	for key := range values {
+		if secretName := s.secretName(key); strings.HasPrefix(secretName, "GITHUB_") {
+			reserved = append(reserved, secretName)
+		}
+	}
+	if len(reserved) > 0 {
+		sort.Strings(reserved)
+		return fmt.Errorf("secret names %s are reserved by GitHub", strings.Join(reserved, ", "))
+	}
+	return nil
+}
+
+func (s *GitHubActions) secretName(key string) string {
+	return variableName(s.Config.Prefix + key)
+}
+
+func (s *GitHubActions) manifestName(name string) string {
+	return "ENV_UPDATER_" + variableName(name)
+}
+
+// manifest reads the object's manifest variable, returning nil when it does not exist
+func (s *GitHubActions) manifest(ctx context.Context, name string) (*actionsManifest, error) {
+	var variable *github.ActionsVariable
+	var err error
+	if s.Config.Environment != "" {
+		variable, _, err = s.Client.Actions.GetEnvVariable(ctx, int(s.repoID), s.Config.Environment, s.manifestName(name))
+	} else {
+		variable, _, err = s.Client.Actions.GetRepoVariable(ctx, s.owner, s.repo, s.manifestName(name))
+	}
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %v", s.manifestName(name), err)
+	}
+
+	var manifest actionsManifest
+	if err := json.Unmarshal([]byte(variable.Value), &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", s.manifestName(name), err)
+	}
+	return &manifest, nil
+}
+
+func (s *GitHubActions) saveManifest(ctx context.Context, name string, manifest actionsManifest, exists bool) error {
+	value, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	variable := &github.ActionsVariable{Name: s.manifestName(name), Value: string(value)}
+
+	switch {
+	case s.Config.Environment != "" && exists:
+		_, err = s.Client.Actions.UpdateEnvVariable(ctx, int(s.repoID), s.Config.Environment, variable)
+	case s.Config.Environment != "":
+		_, err = s.Client.Actions.CreateEnvVariable(ctx, int(s.repoID), s.Config.Environment, variable)
+	case exists:
+		_, err = s.Client.Actions.UpdateRepoVariable(ctx, s.owner, s.repo, variable)
+	default:
+		_, err = s.Client.Actions.CreateRepoVariable(ctx, s.owner, s.repo, variable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save manifest %s: %v", variable.Name, err)
+	}
+	return nil
+}
+
+func (s *GitHubActions) publicKey(ctx context.Context) (*github.PublicKey, error) {
+	var publicKey *github.PublicKey
+	var err error
+	if s.Config.Environment != "" {
+		publicKey, _, err = s.Client.Actions.GetEnvPublicKey(ctx, int(s.repoID), s.Config.Environment)
+	} else {
+		publicKey, _, err = s.Client.Actions.GetRepoPublicKey(ctx, s.owner, s.repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Actions public key for %s: %v", s.Location(), err)
+	}
+	return publicKey, nil
+}
+
+func (s *GitHubActions) putSecret(ctx context.Context, secret *github.EncryptedSecret) error {
+	var err error
+	if s.Config.Environment != "" {
+		_, err = s.Client.Actions.CreateOrUpdateEnvSecret(ctx, int(s.repoID), s.Config.Environment, secret)
+	} else {
+		_, err = s.Client.Actions.CreateOrUpdateRepoSecret(ctx, s.owner, s.repo, secret)
+	}
+	return err
+}
+
+func (s *GitHubActions) deleteSecret(ctx context.Context, name string) error {
+	var err error
+	if s.Config.Environment != "" {
+		_, err = s.Client.Actions.DeleteEnvSecret(ctx, int(s.repoID), s.Config.Environment, name)
+	} else {
+		_, err = s.Client.Actions.DeleteRepoSecret(ctx, s.owner, s.repo, name)
+	}
+	return ignoreNotFound(err)
+}
+
+func (s *GitHubActions) deleteVariable(ctx context.Context, name string) error {
+	var err error
+	if s.Config.Environment != "" {
+		_, err = s.Client.Actions.DeleteEnvVariable(ctx, int(s.repoID), s.Config.Environment, name)
+	} else {
+		_, err = s.Client.Actions.DeleteRepoVariable(ctx, s.owner, s.repo, name)
+	}
+	return err
+}
+
+// isNotFound reports whether a go-github error is a 404
+func isNotFound(err error) bool {
+	if errorResponse, ok := err.(*github.ErrorResponse); ok {
+		return errorResponse.Response != nil && errorResponse.Response.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+func ignoreNotFound(err error) error {
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,249 @@
+package sinks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"env-updater/core"
+	"github.com/google/go-github/v50/github"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// fakeActions serves the Actions secrets and variables API of one repository from memory.
+// Secrets are decrypted with the private half of the public key it hands out.
+type fakeActions struct {
+	mu         sync.Mutex
+	publicKey  *[32]byte
+	privateKey *[32]byte
+	secrets    map[string]string // decrypted values
+	variables  map[string]string
+	requests   []string
+}
+
+func newFakeActions(t *testing.T) *fakeActions {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeActions{publicKey: publicKey, privateKey: privateKey, secrets: map[string]string{}, variables: map[string]string{}}
+}
+
+func (f *fakeActions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	const prefix = "/repos/octo/app/actions/"
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	switch {
+	case r.Method == "GET" && path == "secrets/public-key":
+		writeJSON(w, map[string]string{"key_id": "key-1", "key": base64.StdEncoding.EncodeToString(f.publicKey[:])})
+
+	case r.Method == "PUT" && strings.HasPrefix(path, "secrets/"):
+		var secret github.EncryptedSecret
+		if err := json.NewDecoder(r.Body).Decode(&secret); err != nil || secret.KeyID != "key-1" {
+			http.Error(w, `{"message":"bad secret"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		sealed, err := base64.StdEncoding.DecodeString(secret.EncryptedValue)
+		if err != nil {
+			http.Error(w, `{"message":"bad encoding"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		value, ok := box.OpenAnonymous(nil, sealed, f.publicKey, f.privateKey)
+		if !ok {
+			http.Error(w, `{"message":"bad encryption"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		f.secrets[strings.TrimPrefix(path, "secrets/")] = string(value)
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == "DELETE" && strings.HasPrefix(path, "secrets/"):
+		delete(f.secrets, strings.TrimPrefix(path, "secrets/"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "GET" && strings.HasPrefix(path, "variables/"):
+		name := strings.TrimPrefix(path, "variables/")
+		value, ok := f.variables[name]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, github.ActionsVariable{Name: name, Value: value})
+
+	case (r.Method == "POST" && path == "variables") || (r.Method == "PATCH" && strings.HasPrefix(path, "variables/")):
+		var variable github.ActionsVariable
+		if err := json.NewDecoder(r.Body).Decode(&variable); err != nil {
+			http.Error(w, `{"message":"bad variable"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		if _, exists := f.variables[variable.Name]; exists == (r.Method == "POST") {
+			http.Error(w, `{"message":"conflict"}`, http.StatusConflict)
+			return
+		}
+		f.variables[variable.Name] = variable.Value
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == "DELETE" && strings.HasPrefix(path, "variables/"):
+		delete(f.variables, strings.TrimPrefix(path, "variables/"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func newTestActions(t *testing.T, fake *fakeActions) *GitHubActions {
+	server := newFakeServer(t, fake)
+	client := github.NewClient(server.Client())
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return &GitHubActions{
+		Config: GitHubActionsConfig{Repository: "octo/app", Prefix: "APP_"},
+		Client: client,
+		owner:  "octo",
+		repo:   "app",
+	}
+}
+
+func TestGitHubActionsPut(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		secrets     map[string]string
+		manifest    *actionsManifest
+		content     string
+		wantErr     string
+		wantSecrets map[string]string
+	}{
+		{
+			name:        "keys are written as encrypted secrets",
+			prefix:      "APP_",
+			content:     "API_KEY=secret\nDEBUG=false\n",
+			wantSecrets: map[string]string{"APP_API_KEY": "secret", "APP_DEBUG": "false"},
+		},
+		{
+			name:        "secrets of removed keys are deleted",
+			prefix:      "APP_",
+			secrets:     map[string]string{"APP_API_KEY": "old", "APP_OLD": "old", "UNMANAGED": "kept"},
+			manifest:    &actionsManifest{Hash: "previous", Keys: []string{"APP_API_KEY", "APP_OLD"}},
+			content:     "API_KEY=secret\n",
+			wantSecrets: map[string]string{"APP_API_KEY": "secret", "UNMANAGED": "kept"},
+		},
+		{
+			name:    "names reserved by GitHub are refused",
+			prefix:  "GITHUB_",
+			content: "A=1\n",
+			wantErr: "reserved by GitHub",
+		},
+		{
+			name:    "nothing is written when one name is reserved",
+			secrets: map[string]string{"UNMANAGED": "kept"},
+			content: "A=1\nB=2\nGITHUB_TOKEN=x\nC=3\n",
+			wantErr: "GITHUB_TOKEN are reserved by GitHub",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeActions(t)
+			for name, value := range tt.secrets {
+				fake.secrets[name] = value
+			}
+			if tt.manifest != nil {
+				encoded, _ := json.Marshal(tt.manifest)
+				fake.variables["ENV_UPDATER_APP_ENV"] = string(encoded)
+			}
+			sink := newTestActions(t, fake)
+			sink.Config.Prefix = tt.prefix
+
+			content := []byte(tt.content)
+			err := sink.Put(context.Background(), Object{Name: "app.env", Content: content, Source: Source{Path: "envs/app.env"}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if len(fake.requests) != 0 || len(fake.secrets) != len(tt.secrets) {
+					t.Errorf("requests = %q, secrets = %v, want nothing written", fake.requests, fake.secrets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(fake.secrets) != len(tt.wantSecrets) {
+				t.Errorf("secrets = %v, want %v", fake.secrets, tt.wantSecrets)
+			}
+			for name, want := range tt.wantSecrets {
+				if fake.secrets[name] != want {
+					t.Errorf("secret %s = %q, want %q", name, fake.secrets[name], want)
+				}
+			}
+
+			var manifest actionsManifest
+			if err := json.Unmarshal([]byte(fake.variables["ENV_UPDATER_APP_ENV"]), &manifest); err != nil {
+				t.Fatalf("invalid manifest: %v", err)
+			}
+			var managed []string
+			for name := range tt.wantSecrets {
+				if strings.HasPrefix(name, "APP_") {
+					managed = append(managed, name)
+				}
+			}
+			sort.Strings(managed)
+			if manifest.Hash != core.TargetHash(content) || strings.Join(manifest.Keys, ",") != strings.Join(managed, ",") {
+				t.Errorf("manifest = %+v, want keys %v", manifest, managed)
+			}
+			if strings.Contains(fake.variables["ENV_UPDATER_APP_ENV"], core.ContentHash(content)) {
+				t.Errorf("manifest exposes the plain sha256 of the content")
+			}
+			checkHash(t, sink, "app.env", core.TargetHash(content), true)
+		})
+	}
+}
+
+func TestGitHubActionsDelete(t *testing.T) {
+	fake := newFakeActions(t)
+	fake.secrets = map[string]string{"APP_API_KEY": "secret", "UNMANAGED": "kept"}
+	fake.variables["ENV_UPDATER_APP_ENV"] = `{"hash":"abc","keys":["APP_API_KEY"]}`
+	sink := newTestActions(t, fake)
+
+	if err := sink.Delete(context.Background(), "app.env"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.secrets) != 1 || fake.secrets["UNMANAGED"] != "kept" {
+		t.Errorf("secrets = %v, want only UNMANAGED", fake.secrets)
+	}
+	if _, ok := fake.variables["ENV_UPDATER_APP_ENV"]; ok {
+		t.Errorf("manifest was not deleted")
+	}
+	checkHash(t, sink, "app.env", "", false)
+}
+
+func TestGitHubActionsPlan(t *testing.T) {
+	fake := newFakeActions(t)
+	fake.variables["ENV_UPDATER_APP_ENV"] = `{"hash":"abc","keys":["APP_A","APP_OLD"]}`
+	sink := newTestActions(t, fake)
+
+	change, err := sink.Plan(context.Background(), Object{Name: "app.env", Content: []byte("A=1\nB=2\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(change.KeysAdded, ",") != "APP_B" || strings.Join(change.KeysRemoved, ",") != "APP_OLD" {
+		t.Errorf("Plan = %+v, want APP_B added and APP_OLD removed", change)
+	}
+
+	sink.Config.Prefix = ""
+	if _, err := sink.Plan(context.Background(), Object{Name: "app.env", Content: []byte("GITHUB_TOKEN=x\n")}); err == nil || !strings.Contains(err.Error(), "reserved by GitHub") {
+		t.Errorf("Plan with a reserved name: err = %v", err)
+	}
+}
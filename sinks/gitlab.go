@@ -0,0 +1,182 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"env-updater/core"
+)
+
+// GitLabConfig configures a GitLab CI/CD variable sink
+type GitLabConfig struct {
+	BaseURL          string `json:"base_url,omitempty"` // defaults to GITLAB_URL or https://gitlab.com
+	Project          string `json:"project"`            // numeric id or full path, e.g. group/app
+	Key              string `json:"key,omitempty"`      // variable key, defaults to the file name upper-cased
+	EnvironmentScope string `json:"environment_scope,omitempty"`
+	Protected        bool   `json:"protected,omitempty"`
+}
+
+// GitLabVariable stores each object as a file-type CI/CD variable of a GitLab project
+type GitLabVariable struct {
+	Config GitLabConfig
+	Token  string
+	Client *http.Client
+}
+
+// NewGitLabVariable creates a GitLab sink authenticated with GITLAB_TOKEN
+func NewGitLabVariable(config GitLabConfig) (*GitLabVariable, error) {
+	if config.Project == "" {
+		return nil, fmt.Errorf("gitlab sink needs a project")
+	}
+	token := os.Getenv("GITLAB_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("missing environment variable: GITLAB_TOKEN")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = os.Getenv("GITLAB_URL")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = "https://gitlab.com"
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &GitLabVariable{Config: config, Token: token, Client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// gitlabVariable is a variable as returned by the GitLab API
+type gitlabVariable struct {
+	Key              string `json:"key"`
+	Value            string `json:"value"`
+	VariableType     string `json:"variable_type"`
+	Protected        bool   `json:"protected"`
+	EnvironmentScope string `json:"environment_scope,omitempty"`
+}
+
+func (s *GitLabVariable) Kind() string     { return "gitlab" }
+func (s *GitLabVariable) Location() string { return "gitlab:" + s.Config.Project }
+
+func (s *GitLabVariable) Describe(name string) string {
+	return fmt.Sprintf("GitLab variable %s in project %s", s.key(name), s.Config.Project)
+}
+
+func (s *GitLabVariable) Put(ctx context.Context, obj Object) error {
+	key := s.key(obj.Name)
+	_, exists, err := s.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	variable := gitlabVariable{
+		Key:              key,
+		Value:            string(obj.Content),
+		VariableType:     "file",
+		Protected:        s.Config.Protected,
+		EnvironmentScope: s.Config.EnvironmentScope,
+	}
+	if exists {
+		return s.do(ctx, "PUT", s.variableURL(key), variable, nil)
+	}
+	return s.do(ctx, "POST", s.projectURL()+"/variables", variable, nil)
+}
+
+func (s *GitLabVariable) Delete(ctx context.Context, name string) error {
+	key := s.key(name)
+	if _, exists, err := s.get(ctx, key); err != nil || !exists {
+		return err
+	}
+	return s.do(ctx, "DELETE", s.variableURL(key), nil, nil)
+}
+
+func (s *GitLabVariable) Hash(ctx context.Context, name string) (string, bool, error) {
+	variable, exists, err := s.get(ctx, s.key(name))
+	if err != nil || !exists {
+		return "", exists, err
+	}
+	return core.TargetHash([]byte(variable.Value)), true, nil
+}
+
+// Authorize is a no-op, GitLab variables are available to every pipeline of the project
+func (s *GitLabVariable) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	return nil
+}
+
+// get fetches a variable, reporting false when it does not exist
+func (s *GitLabVariable) get(ctx context.Context, key string) (*gitlabVariable, bool, error) {
+	var variable gitlabVariable
+	err := s.do(ctx, "GET", s.variableURL(key), nil, &variable)
+	if err == errNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &variable, true, nil
+}
+
+func (s *GitLabVariable) key(name string) string {
+	if s.Config.Key != "" {
+		return s.Config.Key
+	}
+	return variableName(name)
+}
+
+func (s *GitLabVariable) projectURL() string {
+	return fmt.Sprintf("%s/api/v4/projects/%s", s.Config.BaseURL, url.PathEscape(s.Config.Project))
+}
+
+func (s *GitLabVariable) variableURL(key string) string {
+	variableURL := s.projectURL() + "/variables/" + url.PathEscape(key)
+	if s.Config.EnvironmentScope != "" {
+		variableURL += "?filter[environment_scope]=" + url.QueryEscape(s.Config.EnvironmentScope)
+	}
+	return variableURL
+}
+
+// do sends a JSON request to the GitLab API
+func (s *GitLabVariable) do(ctx context.Context, method, apiURL string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("PRIVATE-TOKEN", s.Token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gitlab request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("gitlab %s %s failed with status %d: %s", method, apiURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	if out != nil && len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, out); err != nil {
+			return fmt.Errorf("failed to decode gitlab response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"env-updater/core"
+)
+
+// fakeGitLab serves the project variables API of one project from memory
+type fakeGitLab struct {
+	mu        sync.Mutex
+	variables map[string]gitlabVariable
+	requests  []string
+	fail      bool
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("PRIVATE-TOKEN") != "token" {
+		http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if f.fail {
+		http.Error(w, `{"message":"500 Internal Server Error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/api/v4/projects/42/variables")
+	key = strings.TrimPrefix(key, "/")
+	switch r.Method {
+	case "GET":
+		variable, ok := f.variables[key]
+		if !ok {
+			http.Error(w, `{"message":"404 Variable Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, variable)
+	case "POST", "PUT":
+		var variable gitlabVariable
+		if err := json.NewDecoder(r.Body).Decode(&variable); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, exists := f.variables[variable.Key]; exists == (r.Method == "POST") {
+			http.Error(w, `{"message":"conflict"}`, http.StatusBadRequest)
+			return
+		}
+		f.variables[variable.Key] = variable
+		writeJSON(w, variable)
+	case "DELETE":
+		delete(f.variables, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newTestGitLab(t *testing.T, fake *fakeGitLab) *GitLabVariable {
+	server := newFakeServer(t, fake)
+	return &GitLabVariable{
+		Config: GitLabConfig{BaseURL: server.URL, Project: "42"},
+		Token:  "token",
+		Client: server.Client(),
+	}
+}
+
+func TestGitLabVariable(t *testing.T) {
+	content := []byte("API_KEY=secret\n")
+	existing := gitlabVariable{Key: "APP_ENV", Value: "API_KEY=old\n", VariableType: "file"}
+
+	tests := []struct {
+		name      string
+		variables map[string]gitlabVariable
+		fail      bool
+		run       func(ctx context.Context, s *GitLabVariable) error
+		wantErr   bool
+		wantReqs  []string
+		wantValue string // value of APP_ENV afterwards, empty when it must not exist
+	}{
+		{
+			name:      "put creates a missing variable",
+			variables: map[string]gitlabVariable{},
+			run: func(ctx context.Context, s *GitLabVariable) error {
+				return s.Put(ctx, Object{Name: "app.env", Content: content})
+			},
+			wantReqs:  []string{"GET /api/v4/projects/42/variables/APP_ENV", "POST /api/v4/projects/42/variables"},
+			wantValue: string(content),
+		},
+		{
+			name:      "put replaces an existing variable",
+			variables: map[string]gitlabVariable{"APP_ENV": existing},
+			run: func(ctx context.Context, s *GitLabVariable) error {
+				return s.Put(ctx, Object{Name: "app.env", Content: content})
+			},
+			wantReqs:  []string{"GET /api/v4/projects/42/variables/APP_ENV", "PUT /api/v4/projects/42/variables/APP_ENV"},
+			wantValue: string(content),
+		},
+		{
+			name:      "delete removes the variable",
+			variables: map[string]gitlabVariable{"APP_ENV": existing},
+			run: func(ctx context.Context, s *GitLabVariable) error {
+				return s.Delete(ctx, "app.env")
+			},
+			wantReqs: []string{"GET /api/v4/projects/42/variables/APP_ENV", "DELETE /api/v4/projects/42/variables/APP_ENV"},
+		},
+		{
+			name:      "delete of a missing variable succeeds",
+			variables: map[string]gitlabVariable{},
+			run: func(ctx context.Context, s *GitLabVariable) error {
+				return s.Delete(ctx, "app.env")
+			},
+			wantReqs: []string{"GET /api/v4/projects/42/variables/APP_ENV"},
+		},
+		{
+			name:      "server errors are returned",
+			variables: map[string]gitlabVariable{},
+			fail:      true,
+			run: func(ctx context.Context, s *GitLabVariable) error {
+				return s.Put(ctx, Object{Name: "app.env", Content: content})
+			},
+			wantErr:  true,
+			wantReqs: []string{"GET /api/v4/projects/42/variables/APP_ENV"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGitLab{variables: tt.variables, fail: tt.fail}
+			sink := newTestGitLab(t, fake)
+
+			err := tt.run(context.Background(), sink)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(fake.requests, "\n") != strings.Join(tt.wantReqs, "\n") {
+				t.Errorf("requests = %q, want %q", fake.requests, tt.wantReqs)
+			}
+
+			variable, exists := fake.variables["APP_ENV"]
+			if tt.wantValue == "" {
+				if exists {
+					t.Errorf("APP_ENV exists with %q, want it missing", variable.Value)
+				}
+				return
+			}
+			if variable.Value != tt.wantValue || variable.VariableType != "file" {
+				t.Errorf("APP_ENV = %+v, want file variable with %q", variable, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestGitLabVariableHash(t *testing.T) {
+	tests := []struct {
+		name       string
+		variables  map[string]gitlabVariable
+		wantHash   string
+		wantExists bool
+	}{
+		{
+			name:       "existing variable is hashed from its value",
+			variables:  map[string]gitlabVariable{"APP_ENV": {Key: "APP_ENV", Value: "A=1\n"}},
+			wantHash:   core.TargetHash([]byte("A=1\n")),
+			wantExists: true,
+		},
+		{
+			name:      "missing variable",
+			variables: map[string]gitlabVariable{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newTestGitLab(t, &fakeGitLab{variables: tt.variables})
+			checkHash(t, sink, "app.env", tt.wantHash, tt.wantExists)
+		})
+	}
+}
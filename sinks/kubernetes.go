@@ -0,0 +1,324 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"env-updater/core"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// KubernetesConfig configures a Kubernetes Secret sink
+type KubernetesConfig struct {
+	// Cluster selects credentials from KUBERNETES_SERVER_<CLUSTER>, KUBERNETES_TOKEN_<CLUSTER>
+	// and KUBERNETES_CA_FILE_<CLUSTER>. When empty, KUBERNETES_SERVER/TOKEN/CA_FILE are used,
+	// falling back to the in-cluster service account.
+	Cluster     string            `json:"cluster,omitempty"`
+	Namespace   string            `json:"namespace"`
+	SecretName  string            `json:"secret_name,omitempty"`  // defaults to the file name, e.g. api_prod.env -> api-prod-env
+	ManifestDir string            `json:"manifest_dir,omitempty"` // write manifests here instead of applying them
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Annotations recorded on managed Secrets
+const (
+	kubernetesHashAnnotation   = "env-updater/content-hash"
+	kubernetesSourceAnnotation = "env-updater/source-name"
+	kubernetesManagedByLabel   = "app.kubernetes.io/managed-by"
+)
+
+// serviceAccountDir holds the in-cluster credentials
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes converts dotenv objects into Opaque Secrets and applies them with
+// server-side apply, or writes the manifests to a directory for GitOps
+type Kubernetes struct {
+	Config KubernetesConfig
+	Server string
+	Token  string
+	Client *http.Client
+}
+
+// kubernetesSecret is the subset of a v1 Secret the sink reads and writes
+type kubernetesSecret struct {
+	APIVersion string            `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string            `json:"kind" yaml:"kind"`
+	Metadata   kubernetesMeta    `json:"metadata" yaml:"metadata"`
+	Type       string            `json:"type" yaml:"type"`
+	Data       map[string]string `json:"data,omitempty" yaml:"data,omitempty"` // base64-encoded values
+}
+
+type kubernetesMeta struct {
+	Name        string            `json:"name" yaml:"name"`
+	Namespace   string            `json:"namespace" yaml:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// NewKubernetes creates a Kubernetes sink, resolving cluster credentials unless writing manifests
+func NewKubernetes(config KubernetesConfig) (*Kubernetes, error) {
+	if config.Namespace == "" {
+		return nil, fmt.Errorf("kubernetes sink needs a namespace")
+	}
+
+	sink := &Kubernetes{Config: config}
+	if config.ManifestDir != "" {
+		return sink, nil
+	}
+
+	suffix := ""
+	if config.Cluster != "" {
+		suffix = "_" + variableName(config.Cluster)
+	}
+	sink.Server = os.Getenv("KUBERNETES_SERVER" + suffix)
+	sink.Token = os.Getenv("KUBERNETES_TOKEN" + suffix)
+	caFile := os.Getenv("KUBERNETES_CA_FILE" + suffix)
+
+	if sink.Server == "" && config.Cluster == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		sink.Server = "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+		token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %v", err)
+		}
+		sink.Token = strings.TrimSpace(string(token))
+		caFile = filepath.Join(serviceAccountDir, "ca.crt")
+	}
+	if sink.Server == "" {
+		return nil, fmt.Errorf("no Kubernetes API server configured for cluster %q", config.Cluster)
+	}
+	sink.Server = strings.TrimRight(sink.Server, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %v", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	sink.Client = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	return sink, nil
+}
+
+func (s *Kubernetes) Kind() string { return "kubernetes" }
+
+func (s *Kubernetes) Location() string {
+	if s.Config.ManifestDir != "" {
+		return "manifests:" + s.Config.ManifestDir
+	}
+	cluster := s.Config.Cluster
+	if cluster == "" {
+		cluster = "default"
+	}
+	return fmt.Sprintf("kubernetes:%s/%s", cluster, s.Config.Namespace)
+}
+
+func (s *Kubernetes) Describe(name string) string {
+	if s.Config.ManifestDir != "" {
+		return "Secret manifest " + s.manifestPath(name)
+	}
+	return fmt.Sprintf("Secret %s/%s in %s", s.Config.Namespace, s.secretName(name), s.Location())
+}
+
+// Put builds the Secret from the dotenv keys and applies or writes it
+func (s *Kubernetes) Put(ctx context.Context, obj Object) error {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+
+	labels := map[string]string{kubernetesManagedByLabel: "env-updater"}
+	for key, value := range s.Config.Labels {
+		labels[key] = value
+	}
+	secret := kubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: kubernetesMeta{
+			Name:      s.secretName(obj.Name),
+			Namespace: s.Config.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				kubernetesHashAnnotation:   core.TargetHash(obj.Content),
+				kubernetesSourceAnnotation: obj.Name,
+				"env-updater/source":       strings.Trim(obj.Source.Repository+"/"+obj.Source.Path, "/"),
+			},
+		},
+		Type: "Opaque",
+		Data: make(map[string]string, len(values)),
+	}
+	// Apply data rather than stringData: stringData is merged into data without the field manager
+	// owning the resulting keys, so keys removed from the file would never be pruned
+	for key, value := range values {
+		secret.Data[key] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	if s.Config.ManifestDir != "" {
+		manifest, err := yaml.Marshal(secret)
+		if err != nil {
+			return fmt.Errorf("failed to render manifest: %v", err)
+		}
+		local := LocalDirectory{Directory: s.Config.ManifestDir}
+		return local.Put(ctx, Object{Name: filepath.Base(s.manifestPath(obj.Name)), Content: manifest})
+	}
+
+	applyURL := s.secretURL(obj.Name) + "?fieldManager=env-updater&force=true"
+	return s.do(ctx, "PATCH", applyURL, "application/apply-patch+yaml", secret, nil)
+}
+
+func (s *Kubernetes) Delete(ctx context.Context, name string) error {
+	if s.Config.ManifestDir != "" {
+		local := LocalDirectory{Directory: s.Config.ManifestDir}
+		return local.Delete(ctx, filepath.Base(s.manifestPath(name)))
+	}
+
+	err := s.do(ctx, "DELETE", s.secretURL(name), "", nil, nil)
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *Kubernetes) Hash(ctx context.Context, name string) (string, bool, error) {
+	var secret kubernetesSecret
+	if s.Config.ManifestDir != "" {
+		manifest, err := os.ReadFile(s.manifestPath(name))
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if err := yaml.Unmarshal(manifest, &secret); err != nil {
+			return "", true, nil
+		}
+		return secret.Metadata.Annotations[kubernetesHashAnnotation], true, nil
+	}
+
+	err := s.do(ctx, "GET", s.secretURL(name), "", nil, &secret)
+	if err == errNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return secret.Metadata.Annotations[kubernetesHashAnnotation], true, nil
+}
+
+// Authorize is a no-op, access to Secrets is governed by Kubernetes RBAC
+func (s *Kubernetes) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	return nil
+}
+
+// List returns the source names of the Secrets managed by env-updater in the namespace
+func (s *Kubernetes) List(ctx context.Context) ([]string, error) {
+	if s.Config.ManifestDir != "" {
+		return nil, nil
+	}
+
+	var list struct {
+		Items []kubernetesSecret `json:"items"`
+	}
+	listURL := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets?labelSelector=%s",
+		s.Server, url.PathEscape(s.Config.Namespace), url.QueryEscape(kubernetesManagedByLabel+"=env-updater"))
+	if err := s.do(ctx, "GET", listURL, "", nil, &list); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, item := range list.Items {
+		if name := item.Metadata.Annotations[kubernetesSourceAnnotation]; name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// secretName returns the configured Secret name or derives a DNS-1123 name from the file name
+func (s *Kubernetes) secretName(name string) string {
+	if s.Config.SecretName != "" {
+		return s.Config.SecretName
+	}
+	derived := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, name)
+	return strings.Trim(derived, "-")
+}
+
+func (s *Kubernetes) secretURL(name string) string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", s.Server, url.PathEscape(s.Config.Namespace), url.PathEscape(s.secretName(name)))
+}
+
+func (s *Kubernetes) manifestPath(name string) string {
+	return filepath.Join(s.Config.ManifestDir, s.Config.Namespace+"-"+s.secretName(name)+".yaml")
+}
+
+// do sends a request to the Kubernetes API
+func (s *Kubernetes) do(ctx context.Context, method, apiURL, contentType string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kubernetes request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("kubernetes %s %s failed with status %d: %s", method, apiURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	if out != nil && len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, out); err != nil {
+			return fmt.Errorf("failed to decode kubernetes response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package sinks
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"env-updater/core"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeKubernetes serves the Secrets of one namespace from memory
+type fakeKubernetes struct {
+	mu      sync.Mutex
+	secrets map[string]kubernetesSecret
+	applied []string // raw bodies of server-side apply requests
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, `{"kind":"Status","code":401}`, http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/apps/secrets/")
+
+	switch r.Method {
+	case "GET":
+		secret, ok := f.secrets[name]
+		if !ok {
+			http.Error(w, `{"kind":"Status","code":404}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, secret)
+	case "PATCH":
+		if r.Header.Get("Content-Type") != "application/apply-patch+yaml" || r.URL.Query().Get("fieldManager") != "env-updater" {
+			http.Error(w, `{"kind":"Status","code":415}`, http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var secret kubernetesSecret
+		if err := json.Unmarshal(body, &secret); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.applied = append(f.applied, string(body))
+		f.secrets[name] = secret
+		writeJSON(w, secret)
+	case "DELETE":
+		if _, ok := f.secrets[name]; !ok {
+			http.Error(w, `{"kind":"Status","code":404}`, http.StatusNotFound)
+			return
+		}
+		delete(f.secrets, name)
+		w.Write([]byte(`{"kind":"Status","status":"Success"}`))
+	}
+}
+
+func newTestKubernetes(t *testing.T, fake *fakeKubernetes) *Kubernetes {
+	server := newFakeServer(t, fake)
+	return &Kubernetes{
+		Config: KubernetesConfig{Namespace: "apps"},
+		Server: server.URL,
+		Token:  "token",
+		Client: server.Client(),
+	}
+}
+
+func TestKubernetesPut(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous map[string]kubernetesSecret
+		content  string
+		wantData map[string]string
+	}{
+		{
+			name:     "keys are applied as base64 data",
+			content:  "API_KEY=secret\nGREETING=héllo wörld\n",
+			wantData: map[string]string{"API_KEY": "secret", "GREETING": "héllo wörld"},
+		},
+		{
+			name:     "keys removed from the file are left out of the applied data",
+			previous: map[string]kubernetesSecret{"app-env": {Data: map[string]string{"OLD_KEY": "b2xk", "API_KEY": "b2xk"}}},
+			content:  "API_KEY=secret\n",
+			wantData: map[string]string{"API_KEY": "secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeKubernetes{secrets: map[string]kubernetesSecret{}}
+			for name, secret := range tt.previous {
+				fake.secrets[name] = secret
+			}
+			sink := newTestKubernetes(t, fake)
+
+			content := []byte(tt.content)
+			if err := sink.Put(context.Background(), Object{Name: "app.env", Content: content}); err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.applied) != 1 || strings.Contains(fake.applied[0], "stringData") {
+				t.Fatalf("applied = %q, want one apply without stringData", fake.applied)
+			}
+
+			secret := fake.secrets["app-env"]
+			if len(secret.Data) != len(tt.wantData) {
+				t.Errorf("data keys = %v, want %v", secret.Data, tt.wantData)
+			}
+			for key, want := range tt.wantData {
+				decoded, err := base64.StdEncoding.DecodeString(secret.Data[key])
+				if err != nil || string(decoded) != want {
+					t.Errorf("data[%s] = %q (%v), want %q", key, decoded, err, want)
+				}
+			}
+			if secret.Metadata.Labels[kubernetesManagedByLabel] != "env-updater" || secret.Metadata.Annotations[kubernetesSourceAnnotation] != "app.env" {
+				t.Errorf("metadata = %+v", secret.Metadata)
+			}
+			checkHash(t, sink, "app.env", core.TargetHash(content), true)
+		})
+	}
+}
+
+func TestKubernetesDeleteAndHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets map[string]kubernetesSecret
+	}{
+		{
+			name:    "existing secret",
+			secrets: map[string]kubernetesSecret{"app-env": {Metadata: kubernetesMeta{Name: "app-env"}}},
+		},
+		{
+			name:    "missing secret",
+			secrets: map[string]kubernetesSecret{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeKubernetes{secrets: tt.secrets}
+			sink := newTestKubernetes(t, fake)
+
+			if err := sink.Delete(context.Background(), "app.env"); err != nil {
+				t.Fatal(err)
+			}
+			checkHash(t, sink, "app.env", "", false)
+		})
+	}
+}
+
+func TestKubernetesManifest(t *testing.T) {
+	sink, err := NewKubernetes(KubernetesConfig{Namespace: "apps", ManifestDir: filepath.Join(t.TempDir(), "manifests")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("API_KEY=secret\n")
+	if err := sink.Put(context.Background(), Object{Name: "app.env", Content: content}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := os.ReadFile(sink.manifestPath("app.env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secret kubernetesSecret
+	if err := yaml.Unmarshal(manifest, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Data["API_KEY"] != base64.StdEncoding.EncodeToString([]byte("secret")) {
+		t.Errorf("manifest data = %v", secret.Data)
+	}
+	checkHash(t, sink, "app.env", core.TargetHash(content), true)
+}
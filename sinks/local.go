@@ -0,0 +1,109 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"env-updater/core"
+)
+
+// LocalConfig configures a local directory sink
+type LocalConfig struct {
+	Directory string `json:"directory"`
+}
+
+// LocalDirectory writes objects as files in a directory, e.g. a mounted volume
+type LocalDirectory struct {
+	Directory string
+}
+
+// NewLocalDirectory creates a local directory sink; the directory is created on the first write
+func NewLocalDirectory(config LocalConfig) (*LocalDirectory, error) {
+	if config.Directory == "" {
+		return nil, fmt.Errorf("local sink needs a directory")
+	}
+	return &LocalDirectory{Directory: config.Directory}, nil
+}
+
+func (s *LocalDirectory) Kind() string     { return "local" }
+func (s *LocalDirectory) Location() string { return "local:" + s.Directory }
+
+func (s *LocalDirectory) Describe(name string) string {
+	return "file " + s.path(name)
+}
+
+// Put writes the object through a temporary file so readers never see partial content
+func (s *LocalDirectory) Put(ctx context.Context, obj Object) error {
+	if err := os.MkdirAll(s.Directory, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", s.Directory, err)
+	}
+	tmp, err := os.CreateTemp(s.Directory, ".env-updater-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(obj.Content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(obj.Name)); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", s.path(obj.Name), err)
+	}
+	return nil
+}
+
+func (s *LocalDirectory) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalDirectory) Hash(ctx context.Context, name string) (string, bool, error) {
+	content, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return core.TargetHash(content), true, nil
+}
+
+// Authorize is a no-op for local files
+func (s *LocalDirectory) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	return nil
+}
+
+// List returns the files in the directory, none if it was never written to
+func (s *LocalDirectory) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.Directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !isTemporary(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *LocalDirectory) path(name string) string {
+	return filepath.Join(s.Directory, filepath.Base(name))
+}
+
+func isTemporary(name string) bool {
+	matched, _ := filepath.Match(".env-updater-*", name)
+	return matched
+}
@@ -0,0 +1,36 @@
+package sinks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"env-updater/core"
+)
+
+func TestLocalDirectoryCreatedOnPut(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "env")
+	sink, err := NewLocalDirectory(LocalConfig{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Planning builds the sink and reads from it, which must not touch the disk
+	checkHash(t, sink, "app.env", "", false)
+	if names, err := sink.List(context.Background()); err != nil || len(names) != 0 {
+		t.Errorf("List() = %v, %v, want nothing", names, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("directory exists before the first write: %v", err)
+	}
+
+	content := []byte("API_KEY=secret\n")
+	if err := sink.Put(context.Background(), Object{Name: "app.env", Content: content}); err != nil {
+		t.Fatal(err)
+	}
+	checkHash(t, sink, "app.env", core.TargetHash(content), true)
+	if names, err := sink.List(context.Background()); err != nil || len(names) != 1 || names[0] != "app.env" {
+		t.Errorf("List() = %v, %v, want [app.env]", names, err)
+	}
+}
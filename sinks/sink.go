@@ -0,0 +1,101 @@
+// Package sinks writes env files to the backends that consume them, such as
+// Azure DevOps secure files and variable groups, GitLab CI variables or a local directory.
+package sinks
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+)
+
+// Object is a file to be written to a sink
+type Object struct {
+	Name    string // target name, the base name of the source file
+	Content []byte
+	Source  Source
+}
+
+// Source records where an object came from
+type Source struct {
+	Repository string `json:"repository,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	SHA        string `json:"sha,omitempty"`
+	Parent     string `json:"parent,omitempty"` // commit holding the previous version, when known
+	Actor      string `json:"actor,omitempty"`  // who pushed the change
+	Path       string `json:"path,omitempty"`
+}
+
+// Sink stores env files in a backend. Objects are addressed by Object.Name and each
+// sink maps that name to its own identifier (secure file, variable group, secret...).
+type Sink interface {
+	// Kind returns the sink type, e.g. "secure_file"
+	Kind() string
+	// Location identifies where objects are stored, e.g. the Azure project
+	Location() string
+	// Describe returns a human-readable description of the target for name
+	Describe(name string) string
+	// Put creates or replaces the object
+	Put(ctx context.Context, obj Object) error
+	// Delete removes the object, succeeding if it does not exist
+	Delete(ctx context.Context, name string) error
+	// Hash returns the core.TargetHash recorded for the object and whether it exists.
+	// The hash is empty when the object exists but its hash is unknown.
+	Hash(ctx context.Context, name string) (string, bool, error)
+	// Authorize grants the given pipelines access to the object, if the backend has such a concept
+	Authorize(ctx context.Context, name string, pipelineIds []int) error
+}
+
+// Lister is implemented by sinks that can enumerate the objects they hold
+type Lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// Identifier is implemented by sinks whose backend assigns its own id to objects,
+// such as secure file ids. The id is empty when the object does not exist.
+type Identifier interface {
+	ObjectId(ctx context.Context, name string) (string, error)
+}
+
+// Planner is implemented by sinks that can describe a pending change in more detail than Hash
+type Planner interface {
+	Plan(ctx context.Context, obj Object) (Change, error)
+}
+
+// Change describes what Put would do for an object
+type Change struct {
+	KeysAdded   []string `json:"keys_added,omitempty"`
+	KeysRemoved []string `json:"keys_removed,omitempty"`
+}
+
+// errNotFound is returned by the HTTP helpers of sinks for 404 responses
+var errNotFound = errors.New("not found")
+
+// SecretPolicy decides which dotenv keys are stored as secrets
+type SecretPolicy []string
+
+// IsSecret reports whether key matches one of the policy's patterns; an empty policy marks every key secret
+func (p SecretPolicy) IsSecret(key string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	for _, pattern := range p {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// variableName turns a file name such as api_prod.env into API_PROD_ENV
+func variableName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
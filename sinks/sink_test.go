@@ -0,0 +1,34 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeServer serves a fake backend for the duration of a test
+func newFakeServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeJSON writes v as the JSON body of a fake backend response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// checkHash fails the test unless Hash reports wantHash and wantExists for name
+func checkHash(t *testing.T, sink Sink, name, wantHash string, wantExists bool) {
+	t.Helper()
+	hash, exists, err := sink.Hash(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Hash(%s): %v", name, err)
+	}
+	if hash != wantHash || exists != wantExists {
+		t.Errorf("Hash(%s) = %q, %v, want %q, %v", name, hash, exists, wantHash, wantExists)
+	}
+}
@@ -0,0 +1,326 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"env-updater/core"
+	"github.com/joho/godotenv"
+)
+
+// VaultConfig configures a HashiCorp Vault KV v2 sink
+type VaultConfig struct {
+	Address     string `json:"address,omitempty"`      // defaults to VAULT_ADDR
+	Namespace   string `json:"namespace,omitempty"`    // Vault Enterprise namespace, defaults to VAULT_NAMESPACE
+	Mount       string `json:"mount,omitempty"`        // KV v2 mount, defaults to "secret"
+	Path        string `json:"path"`                   // directory the secrets are written under
+	AuthMethod  string `json:"auth_method,omitempty"`  // "token" (VAULT_TOKEN, default) or "approle" (VAULT_ROLE_ID, VAULT_SECRET_ID)
+	AppRolePath string `json:"approle_path,omitempty"` // AppRole auth mount, defaults to "approle"
+}
+
+// Vault writes each dotenv object as one KV v2 secret with the keys as fields.
+// Writes use check-and-set against the version of the last sync, so a change made
+// in Vault since then makes Put fail instead of being overwritten.
+type Vault struct {
+	Config VaultConfig
+	Client *http.Client
+
+	token string
+}
+
+// NewVault creates a Vault sink, logging in with AppRole when configured
+func NewVault(ctx context.Context, config VaultConfig) (*Vault, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("vault sink needs a path")
+	}
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Address == "" {
+		return nil, fmt.Errorf("vault sink needs an address or VAULT_ADDR")
+	}
+	config.Address = strings.TrimRight(config.Address, "/")
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if config.Mount == "" {
+		config.Mount = "secret"
+	}
+	if config.AppRolePath == "" {
+		config.AppRolePath = "approle"
+	}
+
+	sink := &Vault{Config: config, Client: &http.Client{Timeout: 30 * time.Second}}
+
+	switch config.AuthMethod {
+	case "", "token":
+		sink.token = os.Getenv("VAULT_TOKEN")
+		if sink.token == "" {
+			return nil, fmt.Errorf("missing environment variable: VAULT_TOKEN")
+		}
+	case "approle":
+		if err := sink.loginAppRole(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown vault auth method %q", config.AuthMethod)
+	}
+	return sink, nil
+}
+
+func (s *Vault) Kind() string     { return "vault" }
+func (s *Vault) Location() string { return "vault:" + path.Join(s.Config.Mount, s.Config.Path) }
+
+func (s *Vault) Describe(name string) string {
+	return fmt.Sprintf("Vault secret %s", path.Join(s.Config.Mount, s.secretPath(name)))
+}
+
+// Put writes the object's keys as a new secret version and records its source in custom metadata
+func (s *Vault) Put(ctx context.Context, obj Object) error {
+	values, err := godotenv.Unmarshal(string(obj.Content))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s as dotenv: %v", obj.Name, err)
+	}
+
+	version, err := s.syncedVersion(ctx, obj.Name)
+	if err != nil {
+		return err
+	}
+
+	// Check-and-set against the version of our last write, so that changes made outside
+	// env-updater since then are reported instead of overwritten
+	payload := map[string]interface{}{
+		"options": map[string]interface{}{"cas": version},
+		"data":    values,
+	}
+	var written struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	if err := s.do(ctx, "POST", s.dataURL(obj.Name), payload, &written); err != nil {
+		if strings.Contains(err.Error(), "check-and-set") {
+			return fmt.Errorf("%s was modified outside env-updater since version %d: %v", s.Describe(obj.Name), version, err)
+		}
+		return err
+	}
+
+	metadata := map[string]interface{}{
+		"custom_metadata": map[string]string{
+			"content_hash":      core.TargetHash(obj.Content),
+			"synced_version":    strconv.Itoa(written.Data.Version),
+			"source_repository": obj.Source.Repository,
+			"source_ref":        obj.Source.Ref,
+			"source_sha":        obj.Source.SHA,
+			"source_path":       obj.Source.Path,
+			"managed_by":        "env-updater",
+		},
+	}
+	if err := s.do(ctx, "POST", s.metadataURL(obj.Name), metadata, nil); err != nil {
+		return fmt.Errorf("failed to record metadata for %s: %v", s.Describe(obj.Name), err)
+	}
+	return nil
+}
+
+// Delete soft-deletes the latest version, keeping history recoverable in Vault
+func (s *Vault) Delete(ctx context.Context, name string) error {
+	err := s.do(ctx, "DELETE", s.dataURL(name), nil, nil)
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *Vault) Hash(ctx context.Context, name string) (string, bool, error) {
+	metadata, err := s.metadata(ctx, name)
+	if err != nil || metadata == nil {
+		return "", false, err
+	}
+	// A deleted or destroyed latest version counts as missing, so that the next sync restores it
+	latest, ok := metadata.Versions[strconv.Itoa(metadata.CurrentVersion)]
+	if metadata.CurrentVersion == 0 || !ok || latest.deleted() {
+		return "", false, nil
+	}
+	return metadata.CustomMetadata["content_hash"], true, nil
+}
+
+// Authorize is a no-op, access to Vault secrets is governed by Vault policies
+func (s *Vault) Authorize(ctx context.Context, name string, pipelineIds []int) error {
+	return nil
+}
+
+// List returns the secrets directly under the configured path
+func (s *Vault) List(ctx context.Context) ([]string, error) {
+	var result struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	listURL := fmt.Sprintf("%s/v1/%s/metadata/%s", s.Config.Address, s.Config.Mount, strings.Trim(s.Config.Path, "/"))
+	err := s.do(ctx, "LIST", listURL, nil, &result)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range result.Data.Keys {
+		if !strings.HasSuffix(key, "/") {
+			names = append(names, key)
+		}
+	}
+	return names, nil
+}
+
+// vaultMetadata is the metadata of a KV v2 secret
+type vaultMetadata struct {
+	CurrentVersion int                     `json:"current_version"`
+	CustomMetadata map[string]string       `json:"custom_metadata"`
+	Versions       map[string]vaultVersion `json:"versions"`
+}
+
+type vaultVersion struct {
+	DeletionTime string `json:"deletion_time"`
+	Destroyed    bool   `json:"destroyed"`
+}
+
+// deleted reports whether a version was deleted or destroyed. With delete_version_after set,
+// Vault fills in a deletion time in the future for versions that still exist.
+func (v vaultVersion) deleted() bool {
+	if v.Destroyed {
+		return true
+	}
+	if v.DeletionTime == "" {
+		return false
+	}
+	deletionTime, err := time.Parse(time.RFC3339Nano, v.DeletionTime)
+	return err != nil || !deletionTime.After(time.Now())
+}
+
+// metadata reads the metadata of a secret, returning nil when it does not exist
+func (s *Vault) metadata(ctx context.Context, name string) (*vaultMetadata, error) {
+	var result struct {
+		Data vaultMetadata `json:"data"`
+	}
+	err := s.do(ctx, "GET", s.metadataURL(name), nil, &result)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result.Data, nil
+}
+
+// syncedVersion returns the version written by our last sync, recorded in custom metadata. Secrets
+// that were never synced use their latest version, and secrets that do not exist version 0.
+func (s *Vault) syncedVersion(ctx context.Context, name string) (int, error) {
+	metadata, err := s.metadata(ctx, name)
+	if err != nil || metadata == nil {
+		return 0, err
+	}
+	recorded, ok := metadata.CustomMetadata["synced_version"]
+	if !ok {
+		return metadata.CurrentVersion, nil
+	}
+	version, err := strconv.Atoi(recorded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid synced_version %q on %s: %v", recorded, s.Describe(name), err)
+	}
+	return version, nil
+}
+
+// loginAppRole exchanges VAULT_ROLE_ID and VAULT_SECRET_ID for a client token
+func (s *Vault) loginAppRole(ctx context.Context) error {
+	roleID := os.Getenv("VAULT_ROLE_ID")
+	secretID := os.Getenv("VAULT_SECRET_ID")
+	if roleID == "" || secretID == "" {
+		return fmt.Errorf("missing environment variables: VAULT_ROLE_ID or VAULT_SECRET_ID")
+	}
+
+	var result struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	loginURL := fmt.Sprintf("%s/v1/auth/%s/login", s.Config.Address, s.Config.AppRolePath)
+	payload := map[string]string{"role_id": roleID, "secret_id": secretID}
+	if err := s.do(ctx, "POST", loginURL, payload, &result); err != nil {
+		return fmt.Errorf("vault approle login failed: %v", err)
+	}
+	if result.Auth.ClientToken == "" {
+		return fmt.Errorf("vault approle login returned no token")
+	}
+	s.token = result.Auth.ClientToken
+	return nil
+}
+
+func (s *Vault) secretPath(name string) string {
+	return path.Join(strings.Trim(s.Config.Path, "/"), name)
+}
+
+func (s *Vault) dataURL(name string) string {
+	return fmt.Sprintf("%s/v1/%s/data/%s", s.Config.Address, s.Config.Mount, s.secretPath(name))
+}
+
+func (s *Vault) metadataURL(name string) string {
+	return fmt.Sprintf("%s/v1/%s/metadata/%s", s.Config.Address, s.Config.Mount, s.secretPath(name))
+}
+
+// do sends a JSON request to Vault
+func (s *Vault) do(ctx context.Context, method, apiURL string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %v", err)
+		}
+		body = bytes.NewReader(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if s.token != "" {
+		req.Header.Set("X-Vault-Token", s.token)
+	}
+	if s.Config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Config.Namespace)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("vault %s %s failed with status %d: %s", method, apiURL, resp.StatusCode, string(bodyBytes))
+	}
+
+	if out != nil && len(bodyBytes) > 0 {
+		if err := json.Unmarshal(bodyBytes, out); err != nil {
+			return fmt.Errorf("failed to decode vault response: %v", err)
+		}
+	}
+	return nil
+}
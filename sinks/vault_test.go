@@ -0,0 +1,212 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"env-updater/core"
+)
+
+// fakeVault serves the KV v2 data and metadata endpoints of a single secret from memory
+type fakeVault struct {
+	mu       sync.Mutex
+	metadata *vaultMetadata // nil when the secret does not exist
+	data     map[string]string
+	cas      []int // cas option of every data write
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/secret/metadata/apps/app.env":
+		if f.metadata == nil {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"data": f.metadata})
+
+	case r.Method == "POST" && r.URL.Path == "/v1/secret/data/apps/app.env":
+		var body struct {
+			Options struct {
+				CAS int `json:"cas"`
+			} `json:"options"`
+			Data map[string]string `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.cas = append(f.cas, body.Options.CAS)
+		if f.metadata == nil {
+			f.metadata = &vaultMetadata{Versions: map[string]vaultVersion{}}
+		}
+		if body.Options.CAS != f.metadata.CurrentVersion {
+			http.Error(w, `{"errors":["check-and-set parameter did not match the current version"]}`, http.StatusBadRequest)
+			return
+		}
+		f.metadata.CurrentVersion++
+		f.metadata.Versions[strconv.Itoa(f.metadata.CurrentVersion)] = vaultVersion{}
+		f.data = body.Data
+		writeJSON(w, map[string]interface{}{"data": map[string]int{"version": f.metadata.CurrentVersion}})
+
+	case r.Method == "POST" && r.URL.Path == "/v1/secret/metadata/apps/app.env":
+		var body struct {
+			CustomMetadata map[string]string `json:"custom_metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.metadata.CustomMetadata = body.CustomMetadata
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"errors":["unsupported path"]}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestVault(t *testing.T, fake *fakeVault) *Vault {
+	server := newFakeServer(t, fake)
+	return &Vault{
+		Config: VaultConfig{Address: server.URL, Mount: "secret", Path: "apps"},
+		Client: server.Client(),
+		token:  "token",
+	}
+}
+
+func TestVaultPut(t *testing.T) {
+	content := []byte("API_KEY=secret\nDEBUG=false\n")
+
+	tests := []struct {
+		name        string
+		metadata    *vaultMetadata
+		wantErr     string
+		wantCAS     int
+		wantVersion string // synced_version recorded after the write
+	}{
+		{
+			name:        "new secret is written with cas 0",
+			wantCAS:     0,
+			wantVersion: "1",
+		},
+		{
+			name: "cas uses the version of the last sync",
+			metadata: &vaultMetadata{
+				CurrentVersion: 3,
+				CustomMetadata: map[string]string{"synced_version": "3"},
+				Versions:       map[string]vaultVersion{"3": {}},
+			},
+			wantCAS:     3,
+			wantVersion: "4",
+		},
+		{
+			name: "secret never synced is adopted at its latest version",
+			metadata: &vaultMetadata{
+				CurrentVersion: 2,
+				Versions:       map[string]vaultVersion{"2": {}},
+			},
+			wantCAS:     2,
+			wantVersion: "3",
+		},
+		{
+			name: "change made outside env-updater is not overwritten",
+			metadata: &vaultMetadata{
+				CurrentVersion: 4,
+				CustomMetadata: map[string]string{"synced_version": "3"},
+				Versions:       map[string]vaultVersion{"4": {}},
+			},
+			wantErr: "modified outside env-updater since version 3",
+			wantCAS: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeVault{metadata: tt.metadata}
+			sink := newTestVault(t, fake)
+
+			err := sink.Put(context.Background(), Object{Name: "app.env", Content: content, Source: Source{Path: "envs/app.env"}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(fake.cas) != 1 || fake.cas[0] != tt.wantCAS {
+				t.Errorf("cas = %v, want [%d]", fake.cas, tt.wantCAS)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+
+			if fake.data["API_KEY"] != "secret" || fake.data["DEBUG"] != "false" {
+				t.Errorf("data = %v", fake.data)
+			}
+			custom := fake.metadata.CustomMetadata
+			if custom["synced_version"] != tt.wantVersion {
+				t.Errorf("synced_version = %q, want %q", custom["synced_version"], tt.wantVersion)
+			}
+			if custom["content_hash"] != core.TargetHash(content) || custom["source_path"] != "envs/app.env" {
+				t.Errorf("custom metadata = %v", custom)
+			}
+		})
+	}
+}
+
+func TestVaultHash(t *testing.T) {
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	custom := map[string]string{"content_hash": "abc"}
+
+	tests := []struct {
+		name       string
+		metadata   *vaultMetadata
+		wantHash   string
+		wantExists bool
+	}{
+		{
+			name: "missing secret",
+		},
+		{
+			name:       "live latest version",
+			metadata:   &vaultMetadata{CurrentVersion: 2, CustomMetadata: custom, Versions: map[string]vaultVersion{"1": {DeletionTime: past}, "2": {}}},
+			wantHash:   "abc",
+			wantExists: true,
+		},
+		{
+			name:     "deleted latest version",
+			metadata: &vaultMetadata{CurrentVersion: 2, CustomMetadata: custom, Versions: map[string]vaultVersion{"1": {}, "2": {DeletionTime: past}}},
+		},
+		{
+			name:     "destroyed latest version",
+			metadata: &vaultMetadata{CurrentVersion: 1, CustomMetadata: custom, Versions: map[string]vaultVersion{"1": {Destroyed: true}}},
+		},
+		{
+			name:       "deletion scheduled by delete_version_after",
+			metadata:   &vaultMetadata{CurrentVersion: 1, CustomMetadata: custom, Versions: map[string]vaultVersion{"1": {DeletionTime: future}}},
+			wantHash:   "abc",
+			wantExists: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newTestVault(t, &fakeVault{metadata: tt.metadata})
+			checkHash(t, sink, "app.env", tt.wantHash, tt.wantExists)
+		})
+	}
+}
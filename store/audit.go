@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketAudit = []byte("audit")
+
+// AuditEntry is one record of the append-only audit log. Each entry includes the hash of
+// the previous one, so editing or deleting any entry breaks the chain from that point on.
+// Without an audit key (see SetAuditKey) anyone able to write the database can recompute
+// the chain after an edit; with one, the hashes are HMACs that cannot be forged without it.
+type AuditEntry struct {
+	Seq        uint64     `json:"seq"`
+	Time       time.Time  `json:"time"`
+	Type       string     `json:"type"`            // "sync", "delete", "permissions", "pipeline_run" or "rollback"
+	Actor      string     `json:"actor,omitempty"` // who pushed the change
+	Repository string     `json:"repository,omitempty"`
+	SHA        string     `json:"sha,omitempty"`
+	Path       string     `json:"path,omitempty"`
+	Sink       string     `json:"sink,omitempty"`
+	Location   string     `json:"location,omitempty"` // organization and project, or the sink's location
+	Target     string     `json:"target,omitempty"`
+	ObjectId   string     `json:"object_id,omitempty"` // id assigned by the backend, e.g. the secure file id
+	HashBefore string     `json:"hash_before,omitempty"`
+	HashAfter  string     `json:"hash_after,omitempty"`
+	Keys       *AuditKeys `json:"keys,omitempty"`
+	Pipelines  []int      `json:"pipelines,omitempty"` // pipelines authorized on the target
+	Run        *AuditRun  `json:"run,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	PrevHash   string     `json:"prev_hash"`
+	Hash       string     `json:"hash"`
+}
+
+// AuditKeys lists the keys changed by a sync, never their values
+type AuditKeys struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// AuditRun is a pipeline run triggered by a change
+type AuditRun struct {
+	PipelineId int    `json:"pipeline_id"`
+	Pipeline   string `json:"pipeline,omitempty"`
+	RunId      int    `json:"run_id"`
+	URL        string `json:"url,omitempty"`
+}
+
+// AuditFilter selects audit entries; empty fields match everything
+type AuditFilter struct {
+	Type       string
+	Repository string
+	Path       string // substring of the source path
+	Since      time.Time
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Type == "" || entry.Type == f.Type) &&
+		(f.Repository == "" || entry.Repository == f.Repository) &&
+		(f.Path == "" || strings.Contains(entry.Path, f.Path)) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since))
+}
+
+// SetAuditKey sets the key audit entries are authenticated with. It must be set before the
+// first entry is written: entries hashed without it fail verification once it is set.
+func (s *Store) SetAuditKey(key []byte) {
+	if s != nil {
+		s.auditKey = key
+	}
+}
+
+// auditHash computes the hash of an entry, excluding its own hash field, as an HMAC-SHA256
+// with the audit key or a plain SHA-256 without one
+func (s *Store) auditHash(entry AuditEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	if len(s.auditKey) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	mac := hmac.New(sha256.New, s.auditKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// AppendAudit adds an entry to the end of the chain and returns it with its sequence and hashes
+func (s *Store) AppendAudit(entry AuditEntry) (AuditEntry, error) {
+	if s == nil {
+		return entry, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAudit)
+
+		entry.PrevHash = ""
+		if _, last := bucket.Cursor().Last(); last != nil {
+			var previous AuditEntry
+			if err := json.Unmarshal(last, &previous); err != nil {
+				return fmt.Errorf("failed to read last audit entry: %v", err)
+			}
+			entry.PrevHash = previous.Hash
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.Seq = seq
+		entry.Time = entry.Time.UTC()
+		if entry.Time.IsZero() {
+			entry.Time = time.Now().UTC()
+		}
+		if entry.Hash, err = s.auditHash(entry); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(seqKey(seq), data)
+	})
+	return entry, err
+}
+
+// ListAudit returns up to limit entries matching filter, newest first
+func (s *Store) ListAudit(filter AuditFilter, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := s.scan(bucketAudit, func(key, value []byte) bool {
+		var entry AuditEntry
+		if json.Unmarshal(value, &entry) == nil && filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return limit <= 0 || len(entries) < limit
+	})
+	return entries, err
+}
+
+// ExportAudit writes the entries matching filter as JSON lines, oldest first
+func (s *Store) ExportAudit(w io.Writer, filter AuditFilter) error {
+	if s == nil {
+		return nil
+	}
+
+	buffered := bufio.NewWriter(w)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAudit).ForEach(func(key, value []byte) error {
+			var entry AuditEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			if !filter.matches(entry) {
+				return nil
+			}
+			buffered.Write(value)
+			return buffered.WriteByte('\n')
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// VerifyAudit walks the whole chain and returns the number of entries checked, or an
+// error naming the first entry whose hash or link to its predecessor does not match
+func (s *Store) VerifyAudit() (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAudit)
+		prevHash := ""
+		var prevSeq uint64
+		err := bucket.ForEach(func(key, value []byte) error {
+			var entry AuditEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return fmt.Errorf("entry %x is unreadable: %v", key, err)
+			}
+			if entry.Seq != binary.BigEndian.Uint64(key) {
+				return fmt.Errorf("entry %d is stored under sequence %d", entry.Seq, binary.BigEndian.Uint64(key))
+			}
+			if entry.Seq != prevSeq+1 {
+				return fmt.Errorf("entries %d to %d are missing", prevSeq+1, entry.Seq-1)
+			}
+			if entry.PrevHash != prevHash {
+				return fmt.Errorf("entry %d does not link to entry %d", entry.Seq, prevSeq)
+			}
+			expected, err := s.auditHash(entry)
+			if err != nil {
+				return err
+			}
+			if !hmac.Equal([]byte(entry.Hash), []byte(expected)) {
+				return fmt.Errorf("entry %d was modified", entry.Seq)
+			}
+
+			prevHash, prevSeq = entry.Hash, entry.Seq
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The bucket sequence only grows, so entries removed from the end are detected too
+		if prevSeq != bucket.Sequence() {
+			return fmt.Errorf("entries %d to %d are missing", prevSeq+1, bucket.Sequence())
+		}
+		return nil
+	})
+	return count, err
+}
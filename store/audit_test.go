@@ -0,0 +1,155 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// editAudit rewrites the stored entry with seq, bypassing AppendAudit
+func editAudit(t *testing.T, s *Store, seq uint64, edit func(entry *AuditEntry)) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAudit)
+		var entry AuditEntry
+		if err := json.Unmarshal(bucket.Get(seqKey(seq)), &entry); err != nil {
+			return err
+		}
+		edit(&entry)
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(seqKey(seq), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deleteAudit removes the stored entry with seq
+func deleteAudit(t *testing.T, s *Store, seq uint64) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAudit).Delete(seqKey(seq))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAudit(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string // audit key the entries are written with
+		verifyKey string // audit key set before verifying
+		tamper    func(t *testing.T, s *Store)
+		wantErr   string
+	}{
+		{
+			name: "intact chain",
+		},
+		{
+			name:      "intact keyed chain",
+			key:       "audit-key",
+			verifyKey: "audit-key",
+		},
+		{
+			name: "modified entry",
+			tamper: func(t *testing.T, s *Store) {
+				editAudit(t, s, 2, func(entry *AuditEntry) { entry.Actor = "mallory" })
+			},
+			wantErr: "entry 2 was modified",
+		},
+		{
+			name: "entry removed from the middle",
+			tamper: func(t *testing.T, s *Store) {
+				deleteAudit(t, s, 2)
+			},
+			wantErr: "entries 2 to 2 are missing",
+		},
+		{
+			name: "entry removed from the end",
+			tamper: func(t *testing.T, s *Store) {
+				deleteAudit(t, s, 3)
+			},
+			wantErr: "entries 3 to 3 are missing",
+		},
+		{
+			name: "without a key, an edit with a recomputed hash is not detected",
+			tamper: func(t *testing.T, s *Store) {
+				editAudit(t, s, 3, func(entry *AuditEntry) {
+					entry.Actor = "mallory"
+					entry.Hash, _ = s.auditHash(*entry)
+				})
+			},
+		},
+		{
+			name:      "with a key, an edit with a recomputed plain hash is detected",
+			key:       "audit-key",
+			verifyKey: "audit-key",
+			tamper: func(t *testing.T, s *Store) {
+				editAudit(t, s, 3, func(entry *AuditEntry) {
+					entry.Actor = "mallory"
+					entry.Hash, _ = (&Store{}).auditHash(*entry)
+				})
+			},
+			wantErr: "entry 3 was modified",
+		},
+		{
+			name:      "keyed chain verified with another key",
+			key:       "audit-key",
+			verifyKey: "other-key",
+			wantErr:   "entry 1 was modified",
+		},
+		{
+			name:      "key set after entries were written",
+			verifyKey: "audit-key",
+			wantErr:   "entry 1 was modified",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openTestStore(t)
+			s.SetAuditKey([]byte(tt.key))
+			for _, actor := range []string{"alice", "bob", "carol"} {
+				if _, err := s.AppendAudit(AuditEntry{Type: "sync", Actor: actor, Path: "app.env"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.tamper != nil {
+				tt.tamper(t, s)
+			}
+
+			s.SetAuditKey([]byte(tt.verifyKey))
+			count, err := s.VerifyAudit()
+			if tt.wantErr == "" {
+				if err != nil || count != 3 {
+					t.Errorf("VerifyAudit() = %d, %v, want 3 entries", count, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyAudit() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAppendAuditLinksEntries(t *testing.T) {
+	s := openTestStore(t)
+	first, err := s.AppendAudit(AuditEntry{Type: "sync"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AppendAudit(AuditEntry{Type: "delete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Seq != 1 || first.PrevHash != "" || second.Seq != 2 || second.PrevHash != first.Hash {
+		t.Errorf("entries are not chained: %+v, %+v", first, second)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// migrations upgrade the database one schema version at a time. Append new
+// migrations to the end and never edit one that has been released.
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: initial buckets
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketDeliveries, bucketJobs, bucketSyncAttempts, bucketContentHashes, bucketPipelineRuns} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: append-only audit log
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketAudit)
+		return err
+	},
+	// 3: version history, one nested bucket per target key
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketVersions)
+		return err
+	},
+	// 4: hashes of processed payloads, for replay protection
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketPayloads)
+		return err
+	},
+}
+
+// migrate applies the migrations newer than the stored schema version
+func (s *Store) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if value := meta.Get(keySchemaVersion); len(value) == 8 {
+			version = int(binary.BigEndian.Uint64(value))
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("store schema version %d is newer than this build supports (%d)", version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("store migration %d failed: %v", version+1, err)
+			}
+			log.Printf("Applied store migration %d", version+1)
+		}
+
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(version))
+		return meta.Put(keySchemaVersion, value)
+	})
+}
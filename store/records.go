@@ -0,0 +1,203 @@
+package store
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Delivery is a webhook delivery received by the service
+type Delivery struct {
+	Id         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Repository string    `json:"repository,omitempty"`
+	Status     int       `json:"status"` // HTTP status returned to GitHub
+	Files      int       `json:"files"`
+	Error      string    `json:"error,omitempty"`
+
+	PayloadHash string `json:"payload_hash,omitempty"` // sha256 of the body, to reject replays under a new id
+}
+
+// payloadRecord indexes a successfully processed payload by its hash
+type payloadRecord struct {
+	Time     time.Time `json:"time"`
+	Delivery string    `json:"delivery"`
+}
+
+// SyncAttempt is one attempt to write a file to its sink
+type SyncAttempt struct {
+	Time       time.Time `json:"time"`
+	Repository string    `json:"repository,omitempty"`
+	SHA        string    `json:"sha,omitempty"`
+	Path       string    `json:"path"`
+	Sink       string    `json:"sink"`
+	Target     string    `json:"target"`
+	Action     string    `json:"action,omitempty"` // "replace", "upload", "unchanged", "superseded" or "dry_run"
+	Hash       string    `json:"hash,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ContentHash is the last content written to a target
+type ContentHash struct {
+	Target     string    `json:"target"`
+	Hash       string    `json:"hash"`
+	Repository string    `json:"repository,omitempty"`
+	SHA        string    `json:"sha,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// PipelineRun is a pipeline run queued for synced files
+type PipelineRun struct {
+	Time       time.Time `json:"time"`
+	Project    string    `json:"project"`
+	PipelineId int       `json:"pipeline_id"`
+	Pipeline   string    `json:"pipeline,omitempty"`
+	RunId      int       `json:"run_id"`
+	URL        string    `json:"url,omitempty"`
+	Result     string    `json:"result,omitempty"`
+	Paths      []string  `json:"paths,omitempty"`
+}
+
+// SaveDelivery records a webhook delivery. A delivery that was processed successfully is
+// never overwritten, so a later rejected request cannot clear it from replay protection.
+func (s *Store) SaveDelivery(delivery Delivery) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketDeliveries)
+		var existing Delivery
+		if value := bucket.Get([]byte(delivery.Id)); value != nil && json.Unmarshal(value, &existing) == nil {
+			if existing.Status >= 200 && existing.Status < 300 {
+				return nil
+			}
+		}
+		if err := bucket.Put([]byte(delivery.Id), data); err != nil {
+			return err
+		}
+
+		if delivery.PayloadHash == "" || delivery.Status < 200 || delivery.Status >= 300 {
+			return nil
+		}
+		payload, err := json.Marshal(payloadRecord{Time: delivery.Time, Delivery: delivery.Id})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketPayloads).Put([]byte(delivery.PayloadHash), payload)
+	})
+}
+
+// HasPayload reports whether a payload with this hash was processed successfully
+func (s *Store) HasPayload(hash string) (bool, error) {
+	var record payloadRecord
+	return s.get(bucketPayloads, []byte(hash), &record)
+}
+
+// HasDelivery reports whether a delivery id was recorded with a successful status
+func (s *Store) HasDelivery(id string) (bool, error) {
+	var delivery Delivery
+	ok, err := s.get(bucketDeliveries, []byte(id), &delivery)
+	return ok && delivery.Status >= 200 && delivery.Status < 300, err
+}
+
+// ListDeliveries returns up to limit deliveries, most recent first
+func (s *Store) ListDeliveries(limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := s.scan(bucketDeliveries, func(key, value []byte) bool {
+		var delivery Delivery
+		if json.Unmarshal(value, &delivery) == nil {
+			deliveries = append(deliveries, delivery)
+		}
+		return true
+	})
+	// Deliveries are keyed by id, so sort by time before applying the limit
+	sortByTime(deliveries, func(d Delivery) time.Time { return d.Time })
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, err
+}
+
+// jobRecord wraps a job with the time used for retention
+type jobRecord struct {
+	Time time.Time       `json:"time"`
+	Job  json.RawMessage `json:"job"`
+}
+
+// SaveJob stores a job, given as any JSON-serializable value, started at startedAt
+func (s *Store) SaveJob(id string, startedAt time.Time, job interface{}) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.put(bucketJobs, []byte(id), jobRecord{Time: startedAt, Job: data})
+}
+
+// LoadJob loads a job saved with SaveJob into job
+func (s *Store) LoadJob(id string, job interface{}) (bool, error) {
+	var record jobRecord
+	ok, err := s.get(bucketJobs, []byte(id), &record)
+	if !ok || err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(record.Job, job)
+}
+
+// AddSyncAttempt records an attempt to sync a file
+func (s *Store) AddSyncAttempt(attempt SyncAttempt) error {
+	return s.put(bucketSyncAttempts, timeKey(attempt.Time, attempt.Target), attempt)
+}
+
+// ListSyncAttempts returns up to limit attempts for paths containing pathFilter, most recent first
+func (s *Store) ListSyncAttempts(pathFilter string, limit int) ([]SyncAttempt, error) {
+	var attempts []SyncAttempt
+	err := s.scan(bucketSyncAttempts, func(key, value []byte) bool {
+		var attempt SyncAttempt
+		if json.Unmarshal(value, &attempt) == nil && strings.Contains(attempt.Path, pathFilter) {
+			attempts = append(attempts, attempt)
+		}
+		return limit <= 0 || len(attempts) < limit
+	})
+	return attempts, err
+}
+
+// SetContentHash records the content last written to a target
+func (s *Store) SetContentHash(hash ContentHash) error {
+	return s.put(bucketContentHashes, []byte(hash.Target), hash)
+}
+
+// GetContentHash returns the content last written to a target
+func (s *Store) GetContentHash(target string) (ContentHash, bool, error) {
+	var hash ContentHash
+	ok, err := s.get(bucketContentHashes, []byte(target), &hash)
+	return hash, ok, err
+}
+
+// SavePipelineRun records a pipeline run, replacing an earlier record of the same run
+func (s *Store) SavePipelineRun(run PipelineRun) error {
+	return s.put(bucketPipelineRuns, timeKey(run.Time, run.Project+"/"+strconv.Itoa(run.RunId)), run)
+}
+
+// ListPipelineRuns returns up to limit pipeline runs, most recent first
+func (s *Store) ListPipelineRuns(limit int) ([]PipelineRun, error) {
+	var runs []PipelineRun
+	err := s.scan(bucketPipelineRuns, func(key, value []byte) bool {
+		var run PipelineRun
+		if json.Unmarshal(value, &run) == nil {
+			runs = append(runs, run)
+		}
+		return limit <= 0 || len(runs) < limit
+	})
+	return runs, err
+}
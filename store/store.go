@@ -0,0 +1,222 @@
+// Package store persists deliveries, jobs, sync attempts, content hashes, pipeline runs,
+// the audit log and file versions in an embedded bbolt database, so that history survives restarts.
+//
+// The store is optional: every method is a no-op on a nil *Store, which is what Default
+// returns until SetDefault is called with an opened store.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names
+var (
+	bucketMeta           = []byte("meta")
+	bucketDeliveries     = []byte("deliveries")
+	bucketJobs           = []byte("jobs")
+	bucketSyncAttempts   = []byte("sync_attempts")
+	bucketContentHashes  = []byte("content_hashes")
+	bucketPipelineRuns   = []byte("pipeline_runs")
+	bucketPayloads       = []byte("payloads")
+	keySchemaVersion     = []byte("schema_version")
+	timeKeyFormat        = "20060102T150405.000000000Z"
+	defaultOpenTimeout   = time.Second
+	defaultPruneInterval = time.Hour
+)
+
+// Store is a bbolt database holding the service's state
+type Store struct {
+	db       *bolt.DB
+	auditKey []byte // see SetAuditKey
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore *Store
+)
+
+// Default returns the store set with SetDefault, or nil when none is configured
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// SetDefault sets the store used by handlers and services
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Open opens or creates the database at path and applies pending migrations
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: defaultOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %v", path, err)
+	}
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// timeKey builds a key that sorts by time, made unique by suffix
+func timeKey(t time.Time, suffix string) []byte {
+	return []byte(t.UTC().Format(timeKeyFormat) + "/" + suffix)
+}
+
+// put stores v as JSON under key in bucket
+func (s *Store) put(bucket, key []byte, v interface{}) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %v", bucket, err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, data)
+	})
+}
+
+// get loads the JSON record under key into v, reporting whether it exists
+func (s *Store) get(bucket, key []byte, v interface{}) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(bucket).Get(key); value != nil {
+			data = append([]byte{}, value...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// scan calls fn with each record of bucket, newest key first, until fn returns false
+func (s *Store) scan(bucket []byte, fn func(key, value []byte) bool) error {
+	if s == nil {
+		return nil
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucket).Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			if !fn(key, value) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Prune deletes deliveries, jobs, sync attempts and pipeline runs older than cutoff.
+// Content hashes describe the current state of each target and are kept.
+func (s *Store) Prune(cutoff time.Time) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	pruned := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// Keys are collected before deleting, as deleting under a cursor skips the next key
+		var expired [][]byte
+		deleteExpired := func(bucket *bolt.Bucket) error {
+			for _, key := range expired {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			pruned += len(expired)
+			expired = expired[:0]
+			return nil
+		}
+
+		// Records keyed by time are deleted in key order up to the cutoff
+		for _, name := range [][]byte{bucketSyncAttempts, bucketPipelineRuns} {
+			bucket := tx.Bucket(name)
+			cursor := bucket.Cursor()
+			limit := timeKey(cutoff, "")
+			for key, _ := cursor.First(); key != nil && string(key) < string(limit); key, _ = cursor.Next() {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			if err := deleteExpired(bucket); err != nil {
+				return err
+			}
+		}
+
+		// Records keyed by id carry their own timestamp
+		for _, name := range [][]byte{bucketDeliveries, bucketPayloads, bucketJobs} {
+			bucket := tx.Bucket(name)
+			cursor := bucket.Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				var record struct {
+					Time time.Time `json:"time"`
+				}
+				if json.Unmarshal(value, &record) == nil && !record.Time.IsZero() && record.Time.Before(cutoff) {
+					expired = append(expired, append([]byte(nil), key...))
+				}
+			}
+			if err := deleteExpired(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return pruned, err
+}
+
+// StartPruneLoop prunes records older than retention every hour until ctx is cancelled
+func (s *Store) StartPruneLoop(ctx context.Context, retention time.Duration) {
+	if s == nil || retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(defaultPruneInterval)
+		defer ticker.Stop()
+
+		for {
+			pruned, err := s.Prune(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("Store prune error: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d store records older than %s", pruned, retention)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// sortByTime sorts records newest first
+func sortByTime[T any](records []T, timeOf func(T) time.Time) {
+	sort.SliceStable(records, func(i, j int) bool { return timeOf(records[i]).After(timeOf(records[j])) })
+}
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens a store in a temporary directory, closed when the test ends
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "env-updater.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketVersions = []byte("versions")
+
+// Version is a past upload of a file to a sink target. The content itself is kept
+// encrypted alongside it, or only referenced by Repository, Path and Ref.
+type Version struct {
+	Id         uint64    `json:"id"`
+	Key        string    `json:"key"`    // sink kind, location and object name, which versions are kept by
+	Target     string    `json:"target"` // description of the target when the version was written
+	Time       time.Time `json:"time"`
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Ref        string    `json:"ref"` // commit SHA, or the ref the file was fetched at
+	Hash       string    `json:"hash"`
+	Encrypted  bool      `json:"encrypted"` // content is stored, otherwise it is fetched from Ref
+}
+
+// versionRecord stores a version with its encrypted content
+type versionRecord struct {
+	Version
+	Content []byte `json:"content,omitempty"`
+}
+
+// SaveVersion stores a new version of a target, keeping only the newest keep versions.
+// A version with the same hash as the newest one is not stored again.
+func (s *Store) SaveVersion(version Version, content []byte, keep int) (Version, error) {
+	if s == nil || keep <= 0 {
+		return version, nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(bucketVersions).CreateBucketIfNotExists([]byte(version.Key))
+		if err != nil {
+			return err
+		}
+
+		if _, last := bucket.Cursor().Last(); last != nil {
+			var newest versionRecord
+			if err := json.Unmarshal(last, &newest); err == nil && newest.Hash == version.Hash {
+				version = newest.Version
+				return nil
+			}
+		}
+
+		if version.Id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		if version.Time.IsZero() {
+			version.Time = time.Now().UTC()
+		}
+		data, err := json.Marshal(versionRecord{Version: version, Content: content})
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(seqKey(version.Id), data); err != nil {
+			return err
+		}
+
+		// Drop the oldest versions beyond keep
+		var keys [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		for i := 0; i < len(keys)-keep; i++ {
+			if err := bucket.Delete(keys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return version, err
+}
+
+// ListVersions returns the stored versions of the target with key, newest first
+func (s *Store) ListVersions(key string) ([]Version, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	var versions []Version
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketVersions).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for id, value := cursor.Last(); id != nil; id, value = cursor.Prev() {
+			var record versionRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("failed to decode version %x of %s: %v", id, key, err)
+			}
+			versions = append(versions, record.Version)
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// LoadVersion returns a version of the target with key and its encrypted content, if any
+func (s *Store) LoadVersion(key string, id uint64) (Version, []byte, bool, error) {
+	if s == nil {
+		return Version{}, nil, false, nil
+	}
+
+	var record versionRecord
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketVersions).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		value := bucket.Get(seqKey(id))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &record)
+	})
+	return record.Version, record.Content, found, err
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSaveVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		hashes  []string // hashes of the versions saved, oldest first
+		keep    int
+		wantIds []uint64 // ids listed, newest first
+	}{
+		{name: "versions are listed newest first", hashes: []string{"a", "b", "c"}, keep: 5, wantIds: []uint64{3, 2, 1}},
+		{name: "only the newest keep versions are kept", hashes: []string{"a", "b", "c", "d"}, keep: 2, wantIds: []uint64{4, 3}},
+		{name: "an unchanged version is not stored again", hashes: []string{"a", "b", "b"}, keep: 5, wantIds: []uint64{2, 1}},
+		{name: "a version equal to an older one is stored", hashes: []string{"a", "b", "a"}, keep: 5, wantIds: []uint64{3, 2, 1}},
+		{name: "no history is kept with keep 0", hashes: []string{"a", "b"}, keep: 0, wantIds: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openTestStore(t)
+			for _, hash := range tt.hashes {
+				version := Version{Key: "local/dir/app.env", Hash: hash, Ref: "sha-" + hash}
+				if _, err := s.SaveVersion(version, []byte("sealed-"+hash), tt.keep); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			versions, err := s.ListVersions("local/dir/app.env")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint64
+			for _, version := range versions {
+				ids = append(ids, version.Id)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIds) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIds)
+			}
+		})
+	}
+}
+
+func TestLoadVersion(t *testing.T) {
+	s := openTestStore(t)
+	saved, err := s.SaveVersion(Version{Key: "local/dir/app.env", Hash: "a", Encrypted: true}, []byte("sealed"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SaveVersion(Version{Key: "local/dir/other.env", Hash: "b"}, nil, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		key         string
+		id          uint64
+		wantFound   bool
+		wantContent string
+	}{
+		{name: "stored version", key: "local/dir/app.env", id: saved.Id, wantFound: true, wantContent: "sealed"},
+		{name: "unknown id", key: "local/dir/app.env", id: saved.Id + 1},
+		{name: "id of another target", key: "local/dir/missing.env", id: saved.Id},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, content, found, err := s.LoadVersion(tt.key, tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantFound || string(content) != tt.wantContent {
+				t.Errorf("LoadVersion() = %q, %v, want %q, %v", content, found, tt.wantContent, tt.wantFound)
+			}
+			if found && (version.Hash != "a" || !version.Encrypted) {
+				t.Errorf("version = %+v", version)
+			}
+		})
+	}
+}